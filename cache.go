@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// cachePath returns where the file called name is kept in the cache
+// directory of update.
+func cachePath(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "update", name), nil
+}
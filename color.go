@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/shuymn-sandbox/update/updater"
+)
+
+// Colors of the summary. They all have two digits, so that every colored
+// cell is the same number of bytes longer than its text.
+const (
+	colorRed     = 31
+	colorGreen   = 32
+	colorYellow  = 33
+	colorDefault = 39
+)
+
+// terminalWidth returns the width of the terminal f, falling back to
+// COLUMNS and then to 80 columns when it is not known.
+func terminalWidth(f *os.File) int {
+	if n := updater.TerminalWidth(f); n > 0 {
+		return n
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// parseLineWidth parses the value of -max-log-line.
+func parseLineWidth(s string) (int, error) {
+	if s == "auto" {
+		if !updater.IsTerminal(os.Stdout) {
+			return 0, nil
+		}
+		return terminalWidth(os.Stdout), nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid width")
+	}
+	return n, nil
+}
+
+// colorEnabled reports whether f is a terminal and the user has not opted
+// out of color through NO_COLOR.
+func colorEnabled(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return updater.IsTerminal(f)
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/shuymn-sandbox/update/updater"
+	"gopkg.in/yaml.v3"
+)
+
+var defaultCommands = []updater.Command{
+	{Name: "brew", Args: []string{"upgrade"}},
+	{Name: "anyenv", Args: []string{"update"}},
+	{Name: "anyenv", Args: []string{"git", "pull"}},
+	{Name: "stack", Args: []string{"upgrade"}},
+	{Name: "npm", Args: []string{"i", "-g", "npm"}},
+	{Name: "rustup", Args: []string{"self", "update"}},
+}
+
+// defaultConfigPaths returns the places a config is looked for when no
+// -config is given, in order: $XDG_CONFIG_HOME/update/commands.yaml,
+// ~/.config/update/commands.yaml and ~/.update.yaml.
+func defaultConfigPaths() ([]string, error) {
+	var paths []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "update", "commands.yaml"))
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return paths, err
+	}
+	return append(paths,
+		filepath.Join(home, ".config", "update", "commands.yaml"),
+		filepath.Join(home, ".update.yaml"),
+	), nil
+}
+
+// defaultConfigPath is where -init writes the config: the first of
+// defaultConfigPaths.
+func defaultConfigPath() (string, error) {
+	paths, err := defaultConfigPaths()
+	if len(paths) == 0 {
+		return "", err
+	}
+	return paths[0], nil
+}
+
+// Config is the content of a config file. The file is either a list of
+// commands or a mapping with the pre, commands and post lists.
+type Config struct {
+	// Pre runs serially before the commands; a failure aborts the run.
+	Pre      []updater.Command
+	Commands []updater.Command
+	// Post runs serially after the commands, whatever their outcome.
+	Post []updater.Command
+	// Groups maps a group name to the commands in it.
+	Groups map[string]Group
+}
+
+// Group is an entry of groups, written either as the list of its commands
+// or as a mapping that can also limit their parallelism.
+type Group struct {
+	Commands []string `yaml:"commands"`
+	// Parallel limits how many commands of the group run at once in place
+	// of -parallel. Zero leaves them to -parallel.
+	Parallel int `yaml:"parallel"`
+}
+
+func (g *Group) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&g.Commands)
+	}
+	type plain Group
+	return value.Decode((*plain)(g))
+}
+
+// loadConfig reads the config from path, or from stdin when path is "-".
+// When path is empty the first of defaultConfigPaths that exists is used,
+// falling back to the built-in defaults. It also returns where the config
+// came from.
+func loadConfig(path string) (*Config, string, error) {
+	if path == "-" {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, "", err
+		}
+		cfg, err := parseConfig("stdin", b)
+		return cfg, "stdin", err
+	}
+
+	if path == "" {
+		paths, _ := defaultConfigPaths()
+		for _, p := range paths {
+			b, err := ioutil.ReadFile(p)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			cfg, err := parseConfigFile(p, b)
+			return cfg, p, err
+		}
+		return &Config{Commands: defaultCommands}, "the built-in defaults", nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	cfg, err := parseConfigFile(path, b)
+	return cfg, path, err
+}
+
+// loadGenerated runs the executable at path and parses what it prints on
+// stdout as a config. Its stderr is passed through.
+func loadGenerated(path string) (*Config, error) {
+	cmd := exec.Command(path)
+	cmd.Stderr = os.Stderr
+	b, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return nil, fmt.Errorf("generator %s exited with code %d", path, ee.ExitCode())
+		}
+		return nil, fmt.Errorf("generator %s: %w", path, err)
+	}
+	return parseConfig("generator "+path, b)
+}
+
+func parseConfig(path string, b []byte) (*Config, error) {
+	cfg, err := decodeConfig(path, b)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseConfigFile is like parseConfig, with the format picked from the
+// extension of path.
+func parseConfigFile(path string, b []byte) (*Config, error) {
+	cfg, err := decodeConfigFile(path, b)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// decodeConfigFile decodes b as TOML or YAML according to the extension of
+// path. JSON is read as YAML, which it is a subset of. Relative dirs are
+// made absolute against the directory of path.
+func decodeConfigFile(path string, b []byte) (*Config, error) {
+	var cfg *Config
+	var err error
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".json":
+		cfg, err = decodeConfig(path, b)
+	case ".toml":
+		// The document is converted to YAML so that the commands are
+		// decoded the same way, along the same struct tags.
+		var doc map[string]interface{}
+		if _, err := toml.Decode(string(b), &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		y, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		cfg, err = decodeConfig(path, y)
+	default:
+		return nil, fmt.Errorf("%s: unrecognized config format, expected a .yaml, .yml, .json or .toml file", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, cmds := range [][]updater.Command{cfg.Pre, cfg.Commands, cfg.Post} {
+		resolveDirs(cmds, base)
+	}
+	return cfg, nil
+}
+
+// resolveDirs joins the relative dirs of cmds to base. A dir is judged by
+// what it expands to, so that one starting with $HOME is left alone. The
+// dirs of remote commands are relative to the remote home instead.
+func resolveDirs(cmds []updater.Command, base string) {
+	for i := range cmds {
+		c := &cmds[i]
+		if c.Dir != "" && c.Host == "" && !filepath.IsAbs(os.ExpandEnv(c.Dir)) {
+			c.Dir = filepath.Join(base, c.Dir)
+		}
+		resolveDirs(c.OnSuccess, base)
+		resolveDirs(c.OnFailure, base)
+	}
+}
+
+// decodeConfig parses b without checking the references between commands,
+// which may live in another fragment.
+func decodeConfig(path string, b []byte) (*Config, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	cfg := &Config{}
+	if len(doc.Content) == 0 {
+		return cfg, nil
+	}
+
+	var err error
+	root := doc.Content[0]
+	switch root.Kind {
+	case yaml.SequenceNode:
+		var nodes []yaml.Node
+		if err := root.Decode(&nodes); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if cfg.Commands, err = decodeCommands(path, "entry", nodes); err != nil {
+			return nil, err
+		}
+	case yaml.MappingNode:
+		var raw struct {
+			Pre      []yaml.Node      `yaml:"pre"`
+			Commands []yaml.Node      `yaml:"commands"`
+			Post     []yaml.Node      `yaml:"post"`
+			Groups   map[string]Group `yaml:"groups"`
+		}
+		if err := root.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if cfg.Pre, err = decodeCommands(path, "pre entry", raw.Pre); err != nil {
+			return nil, err
+		}
+		if cfg.Commands, err = decodeCommands(path, "commands entry", raw.Commands); err != nil {
+			return nil, err
+		}
+		if cfg.Post, err = decodeCommands(path, "post entry", raw.Post); err != nil {
+			return nil, err
+		}
+		cfg.Groups = raw.Groups
+	default:
+		return nil, fmt.Errorf("%s: expected a list of commands or a mapping", path)
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) validate(path string) error {
+	if err := cfg.assignIDs(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateGroups(cfg.Commands, cfg.Groups); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, cmds := range [][]updater.Command{cfg.Pre, cfg.Commands, cfg.Post} {
+		if err := updater.ValidateDependencies(cmds); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func decodeCommands(path, label string, nodes []yaml.Node) ([]updater.Command, error) {
+	cmds := make([]updater.Command, 0, len(nodes))
+	for i, node := range nodes {
+		var c updater.Command
+		if err := node.Decode(&c); err != nil {
+			return nil, fmt.Errorf("%s: %s %d: %w", path, label, i, err)
+		}
+		if err := checkNames(&c); err != nil {
+			return nil, fmt.Errorf("%s: %s %d: %w", path, label, i, err)
+		}
+		cmds = append(cmds, c)
+	}
+	return cmds, nil
+}
+
+// checkNames checks that c and the commands of its hooks all have a name.
+func checkNames(c *updater.Command) error {
+	if c.Name == "" {
+		return errors.New("name must not be empty")
+	}
+	for _, hooks := range []struct {
+		label string
+		cmds  []updater.Command
+	}{{"on_success", c.OnSuccess}, {"on_failure", c.OnFailure}} {
+		for i := range hooks.cmds {
+			if err := checkNames(&hooks.cmds[i]); err != nil {
+				return fmt.Errorf("%s entry %d: %w", hooks.label, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateGroups(cmds []updater.Command, groups map[string]Group) error {
+	known := make(map[string]bool, len(cmds))
+	for _, c := range cmds {
+		known[c.Name] = true
+		known[c.Ref()] = true
+	}
+	for _, group := range sortedKeys(groups) {
+		if groups[group].Parallel < 0 {
+			return fmt.Errorf("group %s: parallel must not be negative", group)
+		}
+		for _, name := range groups[group].Commands {
+			if !known[name] {
+				return fmt.Errorf("group %s: unknown command %q", group, name)
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]Group) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (cfg *Config) assignIDs() error {
+	for _, cmds := range [][]updater.Command{cfg.Pre, cfg.Commands, cfg.Post} {
+		if err := updater.AssignIDs(cmds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandEnv replaces $VAR and ${VAR} in the names, arguments and working
+// directories of the commands. Undefined variables expand to nothing.
+// Shell commands are left to the shell. The IDs derived from the names
+// are assigned again.
+func (cfg *Config) expandEnv() error {
+	for _, cmds := range [][]updater.Command{cfg.Pre, cfg.Commands, cfg.Post} {
+		expandCommands(cmds)
+	}
+	return cfg.assignIDs()
+}
+
+func expandCommands(cmds []updater.Command) {
+	for i := range cmds {
+		c := &cmds[i]
+		c.Name = os.ExpandEnv(c.Name)
+		c.Dir = os.ExpandEnv(c.Dir)
+		if c.Args != nil {
+			args := make([]string, len(c.Args))
+			for j, a := range c.Args {
+				args[j] = os.ExpandEnv(a)
+			}
+			c.Args = args
+		}
+		expandCommands(c.OnSuccess)
+		expandCommands(c.OnFailure)
+	}
+}
+
+// loadConfigDir appends the config fragments in dir to cfg, in the order of
+// their file names. A fragment may not define a command or group that an
+// earlier source already has unless force is set, in which case it
+// replaces it.
+func loadConfigDir(cfg *Config, dir string, force bool) (*Config, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &Config{}
+	if cfg != nil {
+		*merged = *cfg
+	}
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".yaml", ".yml", ".json", ".toml":
+		default:
+			continue
+		}
+		if e.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, e.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		frag, err := decodeConfigFile(path, b)
+		if err != nil {
+			return nil, err
+		}
+		if err := merged.merge(frag, force); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := merged.validate(dir); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func (cfg *Config) merge(frag *Config, force bool) error {
+	var err error
+	if cfg.Pre, err = mergeCommands(cfg.Pre, frag.Pre, force); err != nil {
+		return err
+	}
+	if cfg.Commands, err = mergeCommands(cfg.Commands, frag.Commands, force); err != nil {
+		return err
+	}
+	if cfg.Post, err = mergeCommands(cfg.Post, frag.Post, force); err != nil {
+		return err
+	}
+
+	if len(frag.Groups) > 0 {
+		groups := make(map[string]Group, len(cfg.Groups)+len(frag.Groups))
+		for name, g := range cfg.Groups {
+			groups[name] = g
+		}
+		for _, name := range sortedKeys(frag.Groups) {
+			if _, ok := groups[name]; ok && !force {
+				return fmt.Errorf("group %s is already defined, use -force to replace it", name)
+			}
+			groups[name] = frag.Groups[name]
+		}
+		cfg.Groups = groups
+	}
+	return nil
+}
+
+// mergeCommands appends add to cmds. With force, the commands in cmds that
+// share a Ref with one in add are dropped first. IDs are not assigned yet,
+// so commands sharing a name are told apart by their id.
+func mergeCommands(cmds, add []updater.Command, force bool) ([]updater.Command, error) {
+	refs := make(map[string]bool, len(add))
+	for _, c := range add {
+		refs[c.Ref()] = true
+	}
+
+	merged := make([]updater.Command, 0, len(cmds)+len(add))
+	for _, c := range cmds {
+		if ref := c.Ref(); refs[ref] {
+			if !force {
+				return nil, fmt.Errorf("command %s is already defined, use -force to replace it", ref)
+			}
+			continue
+		}
+		merged = append(merged, c)
+	}
+	return append(merged, add...), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfigDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		force bool
+		want  []string // the Refs of the merged commands
+		fail  bool
+	}{
+		{
+			name: "same name and distinct ids",
+			files: map[string]string{
+				"a.yaml": "- name: echo\n  id: a\n",
+				"b.yaml": "- name: echo\n  id: b\n",
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "same name",
+			files: map[string]string{
+				"a.yaml": "- name: echo\n",
+				"b.yaml": "- name: echo\n",
+			},
+			fail: true,
+		},
+		{
+			name: "same id",
+			files: map[string]string{
+				"a.yaml": "- name: echo\n  id: a\n",
+				"b.yaml": "- name: printf\n  id: a\n",
+			},
+			fail: true,
+		},
+		{
+			name: "same id with force",
+			files: map[string]string{
+				"a.yaml": "- name: echo\n  id: a\n- name: echo\n  id: b\n",
+				"b.yaml": "- name: printf\n  id: a\n",
+			},
+			force: true,
+			want:  []string{"b", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "update")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg, err := loadConfigDir(nil, dir, tt.force)
+			if tt.fail {
+				if err == nil {
+					t.Fatal("loadConfigDir succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfigDir: %v", err)
+			}
+			var refs []string
+			for _, c := range cfg.Commands {
+				refs = append(refs, c.Ref())
+			}
+			if !reflect.DeepEqual(refs, tt.want) {
+				t.Errorf("commands = %q, want %q", refs, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+const (
+	exitCodeSimple = "simple"
+	exitCodeCount  = "count"
+	// exitCodeNone is selected by -no-fail.
+	exitCodeNone = "none"
+)
+
+// maxCountExitCode keeps -exit-code=count below the codes that shells
+// reserve for their own errors and for signals.
+const maxCountExitCode = 125
+
+// degradedExitCode is the exit code of a run with more failures than
+// -max-failures. It differs from the 2 of invalid flags.
+const degradedExitCode = 3
+
+// failureExitCode maps the number of failed commands to the exit code of
+// the process. In simple mode any failure exits with 1; in count mode the
+// code is the number of failures, capped at maxCountExitCode. In none mode
+// failures are not reflected at all. A maxFailures of zero or more
+// replaces the mode, tolerating up to that many failures and exiting with
+// degradedExitCode past them.
+func failureExitCode(mode string, failed, maxFailures int) int {
+	if failed == 0 || mode == exitCodeNone {
+		return 0
+	}
+	if maxFailures >= 0 {
+		if failed > maxFailures {
+			return degradedExitCode
+		}
+		return 0
+	}
+	if mode == exitCodeCount {
+		if failed > maxCountExitCode {
+			return maxCountExitCode
+		}
+		return failed
+	}
+	return 1
+}
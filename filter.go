@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/shuymn-sandbox/update/updater"
+)
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// filterCommands keeps the commands matching only (all of them when only is
+// empty) and then drops the ones matching skip. Both hold names or shell
+// patterns as understood by path.Match. Patterns that match nothing are
+// reported as warnings.
+func filterCommands(cmds []updater.Command, only, skip []string) []updater.Command {
+	warnUnmatched("-only", cmds, only)
+	warnUnmatched("-skip", cmds, skip)
+
+	filtered := make([]updater.Command, 0, len(cmds))
+	for _, c := range cmds {
+		if len(only) > 0 && !matchCommand(only, &c) {
+			continue
+		}
+		if matchCommand(skip, &c) {
+			continue
+		}
+		filtered = append(filtered, c)
+	}
+	return filtered
+}
+
+func warnUnmatched(flagName string, cmds []updater.Command, patterns []string) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s: %q is not a valid pattern, matching it literally\n", flagName, p)
+		}
+		matched := false
+		for _, c := range cmds {
+			if matchCommand([]string{p}, &c) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fmt.Fprintf(os.Stderr, "warning: %s: no command matches %q\n", flagName, p)
+		}
+	}
+}
+
+// matchCommand reports whether any of patterns matches the name or the ID
+// of c.
+func matchCommand(patterns []string, c *updater.Command) bool {
+	for _, p := range patterns {
+		if matchName(p, c.Name) || matchName(p, c.Ref()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchName reports whether name is pattern or matches it as a glob.
+func matchName(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// selectGroups returns the commands belonging to any of the named groups,
+// or all of them when no group is named.
+func selectGroups(cfg *Config, names []string) ([]updater.Command, error) {
+	if len(names) == 0 {
+		return cfg.Commands, nil
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range names {
+		g, ok := cfg.Groups[name]
+		if !ok {
+			if len(cfg.Groups) == 0 {
+				return nil, fmt.Errorf("unknown group %q: no groups are defined", name)
+			}
+			return nil, fmt.Errorf("unknown group %q (available: %s)", name, strings.Join(sortedKeys(cfg.Groups), ", "))
+		}
+		for _, m := range g.Commands {
+			selected[m] = true
+		}
+	}
+
+	cmds := make([]updater.Command, 0, len(cfg.Commands))
+	for _, c := range cfg.Commands {
+		if selected[c.Name] || selected[c.Ref()] {
+			cmds = append(cmds, c)
+		}
+	}
+	return cmds, nil
+}
+
+// groupLimits returns the limits of the groups that set parallel, in the
+// order of their names.
+func groupLimits(cfg *Config) []updater.Limit {
+	var limits []updater.Limit
+	for _, name := range sortedKeys(cfg.Groups) {
+		if g := cfg.Groups[name]; g.Parallel > 0 {
+			limits = append(limits, updater.Limit{Names: g.Commands, Parallel: g.Parallel})
+		}
+	}
+	return limits
+}
+
+// dedupCommands drops the enabled commands that would run exactly like an
+// earlier one, keeping the first occurrence. dropped is called for each of them.
+func dedupCommands(cmds []updater.Command, dropped func(c *updater.Command)) []updater.Command {
+	seen := make(map[string]bool, len(cmds))
+	deduped := make([]updater.Command, 0, len(cmds))
+	for i := range cmds {
+		if !cmds[i].IsEnabled() {
+			deduped = append(deduped, cmds[i])
+			continue
+		}
+		key := identity(&cmds[i])
+		if seen[key] {
+			dropped(&cmds[i])
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, cmds[i])
+	}
+	return deduped
+}
+
+// identity identifies what c runs and where, ignoring how it is scheduled.
+func identity(c *updater.Command) string {
+	name, args := c.Argv()
+	parts := append([]string{c.Name, name, c.Dir}, args...)
+	keys := make([]string, 0, len(c.Env))
+	for k := range c.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, "env:"+k+"="+c.Env[k])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// splitArgs separates the group names among the arguments left after the
+// flags from the arguments following --. flag stops parsing at -- and
+// drops it, so all is needed to tell whether it was there.
+func splitArgs(all, rest []string) (groups, extra []string) {
+	if n := len(all) - len(rest); n > 0 && all[n-1] == "--" {
+		return nil, rest
+	}
+	for i, a := range rest {
+		if a == "--" {
+			return rest[:i], rest[i+1:]
+		}
+	}
+	return rest, nil
+}
+
+// appendArgs returns cmds with extra added to the arguments of each one,
+// or to the command line of shell commands.
+func appendArgs(cmds []updater.Command, extra []string) []updater.Command {
+	if len(extra) == 0 {
+		return cmds
+	}
+	quoted := make([]string, len(extra))
+	for i, a := range extra {
+		quoted[i] = updater.ShellQuote(a)
+	}
+
+	appended := make([]updater.Command, len(cmds))
+	for i, c := range cmds {
+		if c.Shell != "" {
+			c.Shell += " " + strings.Join(quoted, " ")
+		} else {
+			c.Args = append(append([]string(nil), c.Args...), extra...)
+		}
+		appended[i] = c
+	}
+	return appended
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/shuymn-sandbox/update/updater"
+)
+
+// verbosityFlag lets -verbose behave as a boolean flag: -verbose selects
+// VerbosityDebug, -verbose=false selects VerbosityBrief, and leaving it out
+// keeps VerbosityNormal.
+type verbosityFlag struct {
+	v *updater.Verbosity
+}
+
+func (f verbosityFlag) IsBoolFlag() bool { return true }
+
+func (f verbosityFlag) String() string {
+	if f.v == nil {
+		return ""
+	}
+	switch *f.v {
+	case updater.VerbosityDebug:
+		return "true"
+	case updater.VerbosityBrief:
+		return "false"
+	}
+	return ""
+}
+
+func (f verbosityFlag) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if b {
+		*f.v = updater.VerbosityDebug
+	} else {
+		*f.v = updater.VerbosityBrief
+	}
+	return nil
+}
+
+// timestampsFlag lets -timestamps be given alone for wall-clock times, or
+// as -timestamps=relative.
+type timestampsFlag struct {
+	m *updater.TimestampMode
+}
+
+func (f timestampsFlag) IsBoolFlag() bool { return true }
+
+func (f timestampsFlag) String() string {
+	if f.m == nil {
+		return ""
+	}
+	switch *f.m {
+	case updater.TimestampsWall:
+		return "wall"
+	case updater.TimestampsRelative:
+		return "relative"
+	}
+	return ""
+}
+
+func (f timestampsFlag) Set(s string) error {
+	switch s {
+	case "true", "wall":
+		*f.m = updater.TimestampsWall
+	case "relative":
+		*f.m = updater.TimestampsRelative
+	case "false":
+		*f.m = updater.TimestampsOff
+	default:
+		return fmt.Errorf("expected wall or relative")
+	}
+	return nil
+}
+
+// parallelFlag accepts a number for -parallel, or auto for one command per
+// CPU. auto:N allows N commands per CPU, for commands that mostly wait on
+// the network.
+type parallelFlag struct {
+	n    *int
+	auto bool
+}
+
+func (f *parallelFlag) String() string {
+	if f.n == nil {
+		return "0"
+	}
+	return strconv.Itoa(*f.n)
+}
+
+func (f *parallelFlag) Set(s string) error {
+	f.auto = false
+	if s == "auto" {
+		s = "auto:1"
+	}
+	if m := strings.TrimPrefix(s, "auto:"); m != s {
+		per, err := strconv.Atoi(m)
+		if err != nil || per < 1 {
+			return fmt.Errorf("expected auto:N with N at least 1")
+		}
+		*f.n = per * runtime.NumCPU()
+		f.auto = true
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("expected a number, auto or auto:N")
+	}
+	*f.n = n
+	return nil
+}
+
+// regexpFlag collects the repeated patterns of -redact.
+type regexpFlag []*regexp.Regexp
+
+func (f *regexpFlag) String() string {
+	patterns := make([]string, len(*f))
+	for i, re := range *f {
+		patterns[i] = re.String()
+	}
+	return strings.Join(patterns, ", ")
+}
+
+func (f *regexpFlag) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, re)
+	return nil
+}
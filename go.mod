@@ -2,4 +2,8 @@ module github.com/shuymn-sandbox/update
 
 go 1.14
 
-require golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
+require (
+	github.com/BurntSushi/toml v0.3.1
+	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shuymn-sandbox/update/updater"
+)
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// printGraph writes the dependencies between cmds as a Graphviz digraph,
+// with an edge from each command to the ones waiting for it. Commands that
+// are not in PATH are drawn dashed.
+func printGraph(w io.Writer, cmds []updater.Command, r updater.Runner) {
+	fmt.Fprintln(w, "digraph update {")
+	for i := range cmds {
+		c := &cmds[i]
+		attrs := "tooltip=" + dotQuote(c.String())
+		if !c.Available(r) {
+			attrs += ", style=dashed, fontcolor=gray"
+		}
+		fmt.Fprintf(w, "  %s [%s];\n", dotQuote(c.Ref()), attrs)
+	}
+	for i, deps := range updater.Dependencies(cmds) {
+		for _, d := range deps {
+			fmt.Fprintf(w, "  %s -> %s;\n", dotQuote(cmds[d].Ref()), dotQuote(cmds[i].Ref()))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
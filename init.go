@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const starterHeader = `# Commands run by update. See the README for all the keys.
+#
+# - name: git          # program to run, or the label of a shell command
+#   id: dotfiles       # tells apart commands sharing a name
+#   args: [pull]       # arguments
+#   dir: /src/dotfiles # working directory
+#   env: {FOO: "1"}    # extra environment variables
+#   shell: make update # run through sh -c instead of name and args
+#   host: web1         # run on another machine through ssh
+#   os: [darwin]       # only run on these values of runtime.GOOS
+#   depends_on: [brew] # wait for these commands to finish first
+#   timeout: 10m       # overrides -timeout for this command
+#   retry_on: [2]      # only retry these exit codes under -retries
+#   priority: 10       # start before lower priorities under -parallel
+#   min_interval: 6h   # skip it when it succeeded less than 6h ago
+#   expect_no_match: "Error:" # fail when the output matches
+#   enabled: false     # keep the command without running it
+`
+
+// starterConfig returns a config listing the built-in commands.
+func starterConfig() string {
+	var b strings.Builder
+	b.WriteString(starterHeader)
+	for _, c := range defaultCommands {
+		b.WriteString("\n- name: " + c.Name + "\n")
+		b.WriteString("  args: [" + strings.Join(c.Args, ", ") + "]\n")
+	}
+	return b.String()
+}
+
+// writeStarterConfig writes the starter config to path, which must not
+// exist unless force is set.
+func writeStarterConfig(path string, force bool) error {
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(starterConfig()), 0644)
+}
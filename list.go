@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/shuymn-sandbox/update/updater"
+)
+
+// printList describes cmds without running them, marking whether each one
+// is available in PATH.
+func printList(w io.Writer, cmds []updater.Command, r updater.Runner) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tNAME\tCOMMAND\tDETAILS")
+	for _, c := range cmds {
+		mark := "✗"
+		if c.Available(r) {
+			mark = "✓"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", mark, c.Ref(), c.String(), strings.Join(details(&c), ", "))
+	}
+	tw.Flush()
+}
+
+// printCheck reports for each of cmds whether its program is in PATH and
+// returns the number of missing ones. Commands that would not run here
+// anyway are not counted.
+func printCheck(w io.Writer, cmds []updater.Command, r updater.Runner) int {
+	missing := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tNAME\tPROGRAM")
+	for _, c := range cmds {
+		name, _ := c.Argv()
+		status := "ok"
+		switch {
+		case !c.IsEnabled():
+			status = "disabled"
+		case !c.Supported():
+			status = "other platform"
+		case !c.Available(r):
+			status = "missing"
+			missing++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", status, c.Ref(), name)
+	}
+	tw.Flush()
+	return missing
+}
+
+// details lists the settings of c that affect how or whether it runs.
+func details(c *updater.Command) []string {
+	var d []string
+	if !c.IsEnabled() {
+		d = append(d, "disabled")
+	}
+	if c.Dir != "" {
+		d = append(d, "dir="+c.Dir)
+	}
+	if len(c.Env) > 0 {
+		keys := make([]string, 0, len(c.Env))
+		for k := range c.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d = append(d, "env="+strings.Join(keys, ","))
+	}
+	if len(c.OS) > 0 {
+		d = append(d, "os="+strings.Join(c.OS, ","))
+	}
+	if len(c.DependsOn) > 0 {
+		d = append(d, "depends_on="+strings.Join(c.DependsOn, ","))
+	}
+	if len(c.RetryOn) > 0 {
+		codes := make([]string, len(c.RetryOn))
+		for i, code := range c.RetryOn {
+			codes[i] = strconv.Itoa(code)
+		}
+		d = append(d, "retry_on="+strings.Join(codes, ","))
+	}
+	if c.Priority != 0 {
+		d = append(d, fmt.Sprintf("priority=%d", c.Priority))
+	}
+	if c.Timeout > 0 {
+		d = append(d, "timeout="+c.Timeout.String())
+	}
+	if len(c.OnSuccess) > 0 {
+		d = append(d, fmt.Sprintf("on_success=%d", len(c.OnSuccess)))
+	}
+	if len(c.OnFailure) > 0 {
+		d = append(d, fmt.Sprintf("on_failure=%d", len(c.OnFailure)))
+	}
+	if c.MinInterval > 0 {
+		d = append(d, "min_interval="+c.MinInterval.String())
+	}
+	if c.AllowNonZeroExit {
+		d = append(d, "allow_non_zero_exit")
+	}
+	return d
+}
+
+// askConfirmation prints question to w and reports whether the answer read
+// from r is yes. An empty answer or EOF means no.
+func askConfirmation(r io.Reader, w io.Writer, question string) bool {
+	fmt.Fprint(w, question)
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errLocked is returned by acquireLock when another instance holds the lock.
+var errLocked = errors.New("another update is already running")
+
+// lockHolder describes the process recorded in the lock file at path, for
+// the message shown when it holds the lock.
+func lockHolder(path string) string {
+	pid, ok := lockPID(path)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" (pid %d)", pid)
+}
+
+// lockPID returns the pid recorded in the lock file at path.
+func lockPID(path string) (int, bool) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, false
+	}
+	return pid, true
+}
+
+func writePID(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+func lockDir(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0755)
+}
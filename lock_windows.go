@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// acquireLock creates the file at path, which only one process can do,
+// waiting for it to be removed when wait is set and failing with errLocked
+// otherwise. A crashed run leaves the file behind, so a file whose pid is
+// no longer running is removed and created again. The file cannot be
+// removed while the process that created it still has it open.
+func acquireLock(path string, wait bool) (release func(), err error) {
+	if err := lockDir(path); err != nil {
+		return nil, err
+	}
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			if err := writePID(f); err != nil {
+				f.Close()
+				os.Remove(path)
+				return nil, err
+			}
+			return func() {
+				f.Close()
+				os.Remove(path)
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if pid, ok := lockPID(path); ok && !processRunning(pid) && os.Remove(path) == nil {
+			continue
+		}
+		if !wait {
+			return nil, errLocked
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// processRunning reports whether the process pid is still running. A
+// process that cannot be inspected is assumed to be.
+func processRunning(pid int) bool {
+	const (
+		stillActive                         = 259
+		errorInvalidParameter syscall.Errno = 87
+	)
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return !errors.Is(err, errorInvalidParameter)
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
@@ -1,173 +1,556 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"math/rand"
 	"os"
-	"os/exec"
+	"os/signal"
+	"runtime"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
-	"golang.org/x/sync/errgroup"
+	"github.com/shuymn-sandbox/update/updater"
 )
 
-type Command struct {
-	Name string
-	Args []string
+type ExecutionError struct {
+	Name  string
+	Error error
+	// StartFailed is set when the command could not be started, rather
+	// than running and failing.
+	StartFailed bool
 }
 
-func (c *Command) available() bool {
-	_, err := exec.LookPath(c.Name)
-	return err == nil
+func main() {
+	os.Exit(run())
 }
 
-func (c *Command) print(rd io.Reader, prefix string) error {
-	r := bufio.NewReader(rd)
-	logger := log.New(os.Stdout, prefix, log.Lmsgprefix)
-	for {
-		row, err := r.ReadString('\n')
-		if len(row) > 0 {
-			logger.Print(row)
-		}
+func run() int {
+	opts := updater.Options{Stdout: os.Stdout, Stderr: os.Stderr, Runner: updater.ExecRunner{}}
+	configPath := flag.String("config", "", "path to the command list, or - for stdin (default $XDG_CONFIG_HOME/update/commands.yaml, ~/.config/update/commands.yaml or ~/.update.yaml)")
+	noExpand := flag.Bool("no-expand", false, "do not expand environment variables in the names, args and dirs of the commands")
+	configDir := flag.String("config-dir", "", "directory of config fragments appended to the command list in file name order")
+	generator := flag.String("generator", "", "executable that prints the command list on stdout, used instead of -config")
+	parallel := parallelFlag{n: &opts.Parallel}
+	flag.Var(&parallel, "parallel", "maximum number of commands to run at once (0 means unlimited), or auto for the number of CPUs and auto:N for N times that")
+	flag.BoolVar(&opts.Serial, "serial", false, "run the commands one at a time in the listed order")
+	interactive := flag.Bool("interactive", false, "forward stdin to the commands so that they can prompt; implies -serial")
+	shuffle := flag.Bool("shuffle", false, "run the commands in a random order")
+	seed := flag.Int64("seed", 0, "seed for -shuffle (0 picks one and prints it)")
+	flag.BoolVar(&opts.FailFast, "fail-fast", false, "stop the run as soon as a command fails")
+	flag.BoolVar(&opts.Group, "group", false, "print the output of each command as one block when it finishes")
+	ordered := flag.Bool("ordered", false, "like -group, but print the blocks in the listed order")
+	flag.DurationVar(&opts.Timeout, "timeout", 0, "maximum duration of each command (0 disables the timeout)")
+	flag.DurationVar(&opts.Grace, "grace", 5*time.Second, "time given to a timed out or interrupted command to exit after SIGTERM before it is killed")
+	timeoutTotal := flag.Duration("timeout-total", 0, "maximum duration of the whole run (0 disables the timeout)")
+	flag.IntVar(&opts.Retries, "retries", 0, "number of times to retry a command that exits with a nonzero code")
+	flag.DurationVar(&opts.RetryDelay, "retry-delay", 5*time.Second, "delay between retries")
+	flag.Var(timestampsFlag{&opts.Timestamps}, "timestamps", "put the time of day in front of each line of output; -timestamps=relative shows the time since the command started")
+	maxLogLine := flag.String("max-log-line", "", "cut logged lines longer than this many characters, or auto to fit the terminal (falling back to $COLUMNS, then 80, when its width is unknown)")
+	flag.DurationVar(&opts.MinDuration, "min-duration", 0, "warn about commands that succeed faster than this without any output (0 disables the warning)")
+	flag.DurationVar(&opts.FlushInterval, "flush-interval", 200*time.Millisecond, "log a partial line of output after it waited this long for its end (0 waits for the end)")
+	flag.IntVar(&opts.Tail, "tail", 0, "only show the last N lines of each failed command in the error report (0 shows everything)")
+	flag.IntVar(&opts.MaxCapture, "max-capture", 1<<20, "maximum number of bytes of each output kept for the error report and JSON (0 means unlimited)")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "print the commands that would run without executing them")
+	flag.BoolVar(&opts.EchoCommands, "echo-commands", false, "print each command to stderr before it runs")
+	flag.BoolVar(&opts.FailOnStderr, "fail-on-stderr", false, "treat commands that print to stderr as failed, even when they exit with 0")
+	flag.BoolVar(&opts.FreshEnv, "fresh-env", false, "run the commands with only PATH, HOME and their configured env instead of the whole environment")
+	flag.BoolVar(&opts.Strict, "strict", false, "treat commands missing from PATH as failures, and exit with 1 when there are no commands to run")
+	flag.Var((*regexpFlag)(&opts.Redact), "redact", "regular expression whose matches are replaced with *** in the output of the commands (repeatable)")
+	only := flag.String("only", "", "comma-separated names or glob patterns of the commands to run")
+	skip := flag.String("skip", "", "comma-separated names or glob patterns of the commands not to run")
+	flag.StringVar(&opts.Format, "format", updater.FormatText, "output format: text, json, or jsonl to also stream every line of output as JSON")
+	flag.Var(verbosityFlag{&opts.Verbosity}, "verbose", "log debug messages; -verbose=false only logs when commands start and finish")
+	quiet := flag.Bool("quiet", false, "only print the errors of failed commands")
+	prefixFormat := flag.String("prefix-format", updater.DefaultPrefixFormat, "text/template of the label in front of each line, given .Name and .Stream")
+	noPrefix := flag.Bool("no-prefix", false, "print the output of the commands without a label")
+	noColor := flag.Bool("no-color", false, "disable colored output")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	exitCode := flag.String("exit-code", exitCodeSimple, "exit code on failure: simple (always 1) or count (number of failed commands, at most 125)")
+	confirm := flag.Bool("confirm", false, "list the commands and ask before running them when stdin is a terminal")
+	check := flag.Bool("check", false, "check that the programs of all the commands are in PATH and exit with 1 if any is missing")
+	maxFailures := flag.Int("max-failures", -1, "exit with 0 when at most this many commands fail and with 3 when more do (-1 disables)")
+	noFail := flag.Bool("no-fail", false, "exit with 0 even when commands fail; failures are still reported")
+	list := flag.Bool("list", false, "print the configured commands and exit")
+	graph := flag.Bool("graph", false, "print the dependencies between the commands in Graphviz DOT format and exit")
+	initConfig := flag.Bool("init", false, "write a starter config to the config path and exit")
+	force := flag.Bool("force", false, "let -init overwrite an existing config, -config-dir fragments replace commands of the same name, and run commands regardless of min_interval")
+	notifyDone := flag.Bool("notify", false, "send a desktop notification when the run finishes")
+	interval := flag.Duration("interval", 0, "run again at this interval until interrupted")
+	reportPath := flag.String("report", "", "write the results as JSON to the given file after the run")
+	webhook := flag.String("webhook", "", "POST the results as JSON to the given URL after the run")
+	var webhookHeaders headerFlag
+	flag.Var(&webhookHeaders, "webhook-header", "header sent with -webhook, as \"Name: value\" (repeatable)")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of update itself to the given file")
+	tracePath := flag.String("trace", "", "write an execution trace of update itself to the given file")
+	since := flag.Bool("since", false, "only run the commands that are new or changed since the last successful run with -since")
+	wait := flag.Bool("wait", false, "wait for another running update to finish instead of exiting")
+	logFile := flag.String("log-file", "", "append all output to the given file as well, compressed when it ends in .gz")
+	logMaxSize := flag.Int64("log-max-size", 0, "rotate the -log-file to FILE.1.gz once it grows past this many bytes (0 disables)")
+	flag.Parse()
+
+	if *cpuProfile != "" || *tracePath != "" {
+		stop, err := startProfiling(*cpuProfile, *tracePath)
 		if err != nil {
-			if err == io.EOF {
-				return nil
+			fmt.Fprintf(os.Stderr, "failed to start profiling: %v\n", err)
+			return 1
+		}
+		defer stop()
+	}
+
+	if *showVersion {
+		fmt.Println(versionString())
+		return 0
+	}
+
+	if *initConfig {
+		path := *configPath
+		if path == "" || path == "-" {
+			p, err := defaultConfigPath()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to locate the config: %v\n", err)
+				return 1
 			}
-			return err
+			path = p
+		}
+		if err := writeStarterConfig(path, *force); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write config: %v\n", err)
+			return 1
 		}
+		fmt.Printf("wrote %s\n", path)
+		return 0
+	}
+
+	if *ordered {
+		opts.Group = true
+		opts.Ordered = true
 	}
-}
 
-func (c *Command) copy(rd io.Reader) (string, error) {
-	r := bufio.NewReader(rd)
-	var b strings.Builder
-	for {
-		row, err := r.ReadString('\n')
-		if len(row) > 0 {
-			b.WriteString(row)
+	opts.Color = !*noColor && colorEnabled(os.Stdout)
+	if *noPrefix {
+		*prefixFormat = ""
+	}
+	if *prefixFormat != updater.DefaultPrefixFormat {
+		t, err := updater.ParsePrefixFormat(*prefixFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -prefix-format: %v\n", err)
+			return 2
 		}
+		opts.PrefixFormat = t
+	}
+	if *quiet {
+		opts.Verbosity = updater.VerbosityQuiet
+	}
+
+	// Only -interactive hands stdin to the commands; they run one at a
+	// time so that they can share it, unless it is where the config comes
+	// from. Otherwise their stdin is empty.
+	if *interactive && *configPath == "-" {
+		fmt.Fprintln(os.Stderr, "-interactive cannot be used with -config -, which reads stdin")
+		return 2
+	}
+	if *generator != "" && *configPath != "" {
+		fmt.Fprintln(os.Stderr, "-generator cannot be used with -config")
+		return 2
+	}
+	if *interactive {
+		opts.Serial = true
+		opts.Stdin = os.Stdin
+	}
+
+	if opts.Parallel < 0 {
+		fmt.Fprintln(os.Stderr, "-parallel must not be negative")
+		return 2
+	}
+	if parallel.auto {
+		opts.Debugf("running at most %d commands at once on %d CPUs", opts.Parallel, runtime.NumCPU())
+	}
+	if *maxLogLine != "" {
+		width, err := parseLineWidth(*maxLogLine)
 		if err != nil {
-			if err == io.EOF {
-				return b.String(), nil
-			}
-			return b.String(), err
+			fmt.Fprintf(os.Stderr, "invalid -max-log-line %q\n", *maxLogLine)
+			return 2
 		}
+		opts.MaxLogLine = width
+	}
+	if opts.Tail < 0 {
+		fmt.Fprintln(os.Stderr, "-tail must not be negative")
+		return 2
+	}
+	if opts.MaxCapture < 0 {
+		fmt.Fprintln(os.Stderr, "-max-capture must not be negative")
+		return 2
+	}
+	if *logMaxSize < 0 {
+		fmt.Fprintln(os.Stderr, "-log-max-size must not be negative")
+		return 2
+	}
+	if opts.Retries < 0 {
+		fmt.Fprintln(os.Stderr, "-retries must not be negative")
+		return 2
+	}
+	if *exitCode != exitCodeSimple && *exitCode != exitCodeCount {
+		fmt.Fprintf(os.Stderr, "unknown -exit-code %q\n", *exitCode)
+		return 2
+	}
+	if *maxFailures < -1 {
+		fmt.Fprintln(os.Stderr, "-max-failures must be -1 or more")
+		return 2
+	}
+	if *noFail {
+		*exitCode = exitCodeNone
+	}
+	if opts.Format != updater.FormatText && opts.Format != updater.FormatJSON && opts.Format != updater.FormatJSONL {
+		fmt.Fprintf(os.Stderr, "unknown -format %q\n", opts.Format)
+		return 2
 	}
-}
 
-func (c *Command) execute() error {
-	if !c.available() {
-		return nil
+	if *logFile != "" {
+		f, err := openLogFile(*logFile, *logMaxSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open log file: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		opts.Stdout = io.MultiWriter(os.Stdout, f)
+		opts.Stderr = io.MultiWriter(os.Stderr, f)
 	}
 
-	cmd := exec.Command(c.Name, c.Args...)
+	// Without streamed output a long command would look stuck, so a
+	// spinner is shown for each running one.
+	if updater.IsTerminal(os.Stdout) && opts.Format == updater.FormatText && !opts.DryRun && (opts.Verbosity == updater.VerbosityBrief || opts.Group && opts.Verbosity > updater.VerbosityQuiet) {
+		opts.Progress = updater.NewProgress(os.Stdout, 100*time.Millisecond)
+		defer opts.Progress.Close()
+		opts.Stdout = opts.Progress.Wrap(opts.Stdout)
+		opts.Stderr = opts.Progress.Wrap(opts.Stderr)
+	}
 
-	stdout, err := cmd.StdoutPipe()
+	// Fragments are added to -config or -generator when one is given, and
+	// replace the default config otherwise.
+	var cfg *Config
+	var err error
+	switch {
+	case *generator != "":
+		cfg, err = loadGenerated(*generator)
+	case *configPath != "" || *configDir == "":
+		var source string
+		cfg, source, err = loadConfig(*configPath)
+		if err == nil {
+			opts.Debugf("loaded config from %s", source)
+		}
+	}
+	if err == nil && *configDir != "" {
+		cfg, err = loadConfigDir(cfg, *configDir, *force)
+	}
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return 1
 	}
-	defer stdout.Close()
-
-	stderr, err := cmd.StderrPipe()
+	if !*noExpand {
+		if err := cfg.expandEnv(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+			return 1
+		}
+	}
+	groups, extraArgs := splitArgs(os.Args[1:], flag.Args())
+	cmds, err := selectGroups(cfg, groups)
 	if err != nil {
-		return err
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	opts.Limits = groupLimits(cfg)
+	cmds = filterCommands(cmds, splitList(*only), splitList(*skip))
+	var snap snapshot
+	var snapPath string
+	var changed []updater.Command
+	if *since {
+		snapPath, err = cachePath("snapshot.json")
+		if err == nil {
+			snap, err = loadSnapshot(snapPath)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load the config snapshot: %v\n", err)
+			return 1
+		}
+		changed = snap.changed(cmds)
+		opts.Debugf("%d of %d commands changed since the last run", len(changed), len(cmds))
+		cmds = changed
+	}
+	cmds = appendArgs(cmds, extraArgs)
+	cmds = dedupCommands(cmds, func(c *updater.Command) {
+		opts.Debugf("skipping duplicate command %s", c)
+	})
+	if *shuffle {
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		if opts.Format == updater.FormatText {
+			fmt.Fprintf(os.Stderr, "shuffling with -seed %d\n", *seed)
+		}
+		r := rand.New(rand.NewSource(*seed))
+		r.Shuffle(len(cmds), func(i, j int) { cmds[i], cmds[j] = cmds[j], cmds[i] })
 	}
 
-	if err = cmd.Start(); err != nil {
-		return err
+	// A filter that excludes everything is most likely a typo, which a
+	// silent success would hide.
+	if len(cmds) == 0 {
+		fmt.Fprintln(os.Stderr, "no commands to run")
+		if opts.Strict {
+			return 1
+		}
+		return 0
 	}
 
-	prefix := "[" + c.Name + "] "
-	var eg errgroup.Group
+	if *check {
+		all := append(append(append([]updater.Command(nil), cfg.Pre...), cmds...), cfg.Post...)
+		if missing := printCheck(os.Stdout, all, opts.Runner); missing > 0 {
+			fmt.Fprintf(os.Stderr, "%d commands are missing\n", missing)
+			return 1
+		}
+		return 0
+	}
 
-	eg.Go(func() error {
-		return c.print(stdout, prefix)
-	})
+	if *list {
+		if len(cfg.Pre) > 0 || len(cfg.Post) > 0 {
+			fmt.Println("pre:")
+			printList(os.Stdout, cfg.Pre, opts.Runner)
+			fmt.Println("\ncommands:")
+			printList(os.Stdout, cmds, opts.Runner)
+			fmt.Println("\npost:")
+			printList(os.Stdout, cfg.Post, opts.Runner)
+		} else {
+			printList(os.Stdout, cmds, opts.Runner)
+		}
+		return 0
+	}
 
-	eg.Go(func() error {
-		str, err := c.copy(stderr)
-		if err != nil {
-			return err
+	if *graph {
+		printGraph(os.Stdout, cmds, opts.Runner)
+		return 0
+	}
+
+	for _, c := range cmds {
+		if c.MinInterval <= 0 {
+			continue
 		}
-		if str != "" {
-			return errors.New(str)
+		path, err := cachePath("state.json")
+		if err == nil {
+			opts.Cache, err = updater.LoadRunCache(path)
 		}
-		return nil
-	})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load the run cache: %v\n", err)
+			return 1
+		}
+		opts.Cache.Ignore = *force
+		break
+	}
 
-	if err = eg.Wait(); err != nil {
-		return err
+	// Without a terminal there is nobody to answer, so the prompt is
+	// skipped rather than blocking automation.
+	if *confirm && !opts.DryRun && updater.IsTerminal(os.Stdin) {
+		printList(os.Stdout, cmds, opts.Runner)
+		if !askConfirmation(os.Stdin, os.Stdout, fmt.Sprintf("about to run %d commands, continue? [y/N] ", len(cmds))) {
+			fmt.Println("aborted")
+			return 0
+		}
 	}
 
-	if err = cmd.Wait(); err != nil {
-		return err
+	// Two package managers of the same kind running at once can corrupt
+	// their state, so only one update runs at a time. A dry run touches
+	// nothing.
+	if !opts.DryRun {
+		path, err := cachePath("lock")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to take the lock: %v\n", err)
+			return 1
+		}
+		release, err := acquireLock(path, false)
+		if errors.Is(err, errLocked) && *wait {
+			fmt.Fprintf(os.Stderr, "waiting for the update%s that is already running\n", lockHolder(path))
+			release, err = acquireLock(path, true)
+		}
+		if errors.Is(err, errLocked) {
+			fmt.Fprintf(os.Stderr, "%v%s, use -wait to wait for it\n", err, lockHolder(path))
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to take the lock: %v\n", err)
+			return 1
+		}
+		defer release()
 	}
 
-	return nil
-}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-type ExecutionError struct {
-	Name  string
-	Error error
-}
+	var running updater.RunningSet
+	opts.Running = &running
+	var interrupted int32
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		atomic.StoreInt32(&interrupted, 1)
+		fmt.Fprintf(opts.Stderr, "interrupted, terminating %d running commands\n", len(running.Names()))
+		cancel()
+	}()
 
-func main() {
-	cmds := []Command{
-		{Name: "brew", Args: []string{"upgrade"}},
-		{Name: "anyenv", Args: []string{"update"}},
-		{Name: "anyenv", Args: []string{"git", "pull"}},
-		{Name: "stack", Args: []string{"upgrade"}},
-		{Name: "npm", Args: []string{"i", "-g", "npm"}},
-		{Name: "rustup", Args: []string{"self", "update"}},
-	}
-
-	errChan := make(chan ExecutionError, len(cmds))
-	var wg sync.WaitGroup
-
-	for _, cmd := range cmds {
-		wg.Add(1)
-		cmd := cmd
-		go func() {
-			defer wg.Done()
-			if err := cmd.execute(); err != nil {
-				errChan <- ExecutionError{
-					Name:  cmd.Name,
-					Error: err,
+	// runOnce runs the hooks and the commands once and returns the exit
+	// code of that run.
+	runOnce := func(ctx context.Context) int {
+		start := time.Now()
+		if *timeoutTotal > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeoutTotal)
+			defer cancel()
+			go func() {
+				<-ctx.Done()
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					fmt.Fprintf(opts.Stderr, "total timeout of %v reached, terminating: %s\n", *timeoutTotal, strings.Join(running.Names(), ", "))
 				}
+			}()
+		}
+
+		jw := newJSONWriter(opts.Stdout)
+		opts.OnResult = func(res *updater.Result) {
+			if opts.Format != updater.FormatText {
+				jw.write(res)
 			}
-		}()
-	}
+		}
 
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
+		// The report is written and sent however the run ends, so that it
+		// can be inspected when something went wrong. Neither changes the
+		// exit code.
+		var preResults, results []updater.Result
+		if *reportPath != "" || *webhook != "" {
+			defer func() {
+				b, err := marshalReport(preResults, results, time.Since(start))
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to encode report: %v\n", err)
+					return
+				}
+				if *reportPath != "" {
+					if err := ioutil.WriteFile(*reportPath, b, 0644); err != nil {
+						fmt.Fprintf(os.Stderr, "failed to write report: %v\n", err)
+					}
+				}
+				if *webhook != "" {
+					if err := postWebhook(*webhook, webhookHeaders, b); err != nil {
+						fmt.Fprintf(os.Stderr, "failed to deliver webhook: %v\n", err)
+					}
+				}
+			}()
+		}
 
-	errors := make([]ExecutionError, 0, len(cmds))
-	for err := range errChan {
-		errors = append(errors, err)
-	}
+		// Hooks reuse the regular execution path but always run serially.
+		hookOpts := opts
+		hookOpts.Serial = true
 
-	code := 0
-	if len(errors) > 0 {
-		logger := log.New(os.Stderr, "", log.Lmsgprefix)
-		for _, err := range errors {
-			fmt.Print("\n")
-			logger.SetPrefix("[" + err.Name + "] ")
-			s := bufio.NewScanner(strings.NewReader(err.Error.Error()))
-			for s.Scan() {
-				logger.Print(s.Text())
+		var preErrs []ExecutionError
+		if len(cfg.Pre) > 0 {
+			preOpts := hookOpts
+			preOpts.FailFast = true
+			var err error
+			preResults, err = updater.Run(ctx, cfg.Pre, preOpts)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			preErrs = failures(preResults)
+		}
+		if len(preErrs) > 0 {
+			if opts.Format == updater.FormatText {
+				printErrors(preErrs, &opts)
+				fmt.Fprintln(opts.Stderr, "a pre hook failed, skipping the update")
 			}
+			return failureExitCode(*exitCode, len(preErrs), -1)
+		}
+
+		results, err := updater.Run(ctx, cmds, opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		execErrs := failures(results)
+
+		// A failing post hook is reported, but the exit code only reflects
+		// the commands themselves.
+		var postErrs []ExecutionError
+		if len(cfg.Post) > 0 && ctx.Err() == nil {
+			postResults, err := updater.Run(ctx, cfg.Post, hookOpts)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			postErrs = failures(postResults)
+		}
+
+		if opts.Cache != nil && !opts.DryRun {
+			if err := opts.Cache.Save(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to save the run cache: %v\n", err)
+			}
+		}
 
-			if s.Err() != nil {
-				fmt.Printf("Scanner error: %q\n", s.Err())
+		// The snapshot only moves forward when everything ran, so that a
+		// failed command is tried again next time.
+		if snap != nil && !opts.DryRun && len(execErrs) == 0 && ctx.Err() == nil {
+			snap.update(changed)
+			if err := snap.save(snapPath); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to save the config snapshot: %v\n", err)
 			}
 		}
-		code = 1
+
+		counts := countResults(results)
+		if opts.Logs(updater.VerbosityBrief) && !opts.DryRun && len(results) > 0 {
+			fmt.Fprint(opts.Stdout, "\n")
+			printSummary(opts.Stdout, results, opts.Color)
+			printTotal(opts.Stdout, time.Since(start), len(results), counts)
+		}
+		if opts.Format != updater.FormatText {
+			jw.writeTotal(time.Since(start), len(results), counts)
+		}
+
+		if opts.Format == updater.FormatText {
+			printErrors(execErrs, &opts)
+			if len(postErrs) > 0 {
+				printErrors(postErrs, &opts)
+				fmt.Fprintln(opts.Stderr, "a post hook failed")
+			}
+		}
+		if *notifyDone {
+			notify(&opts, counts.OK, counts.Failed)
+		}
+		return failureExitCode(*exitCode, len(execErrs), *maxFailures)
+	}
+
+	var code int
+	if *interval > 0 {
+		// A failing cycle does not stop the next one; only an interruption
+		// ends the loop.
+	cycles:
+		for {
+			if opts.Format == updater.FormatText && opts.Logs(updater.VerbosityBrief) {
+				fmt.Fprintf(opts.Stdout, "=== %s\n", time.Now().Format(time.RFC3339))
+			}
+			code = runOnce(ctx)
+			select {
+			case <-time.After(*interval):
+			case <-ctx.Done():
+				break cycles
+			}
+		}
+	} else {
+		code = runOnce(ctx)
+	}
+
+	if atomic.LoadInt32(&interrupted) == 1 {
+		code = 130
 	}
 
-	os.Exit(code)
+	return code
 }
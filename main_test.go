@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs update itself instead of the tests when the test binary
+// is started by runUpdate.
+func TestMain(m *testing.M) {
+	if os.Getenv("UPDATE_TEST_MAIN") == "1" {
+		os.Exit(run())
+	}
+	os.Exit(m.Run())
+}
+
+// runUpdate runs update with args in a home directory of its own and
+// returns what it printed.
+func runUpdate(t *testing.T, stdin *os.File, args ...string) (string, error) {
+	t.Helper()
+	home, err := ioutil.TempDir("", "update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(),
+		"UPDATE_TEST_MAIN=1",
+		"HOME="+home,
+		"XDG_CACHE_HOME="+filepath.Join(home, "cache"),
+		"XDG_CONFIG_HOME="+filepath.Join(home, "config"),
+	)
+	cmd.Stdin = stdin
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// writeConfig writes content to a config file in a new directory.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfirmWithoutTerminal(t *testing.T) {
+	if _, err := exec.LookPath("echo"); err != nil {
+		t.Skip("no echo in PATH")
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+
+	config := writeConfig(t, "commands.yaml", "- name: echo\n  args: [ran]\n")
+	out, err := runUpdate(t, devNull, "-confirm", "-config", config)
+	if err != nil {
+		t.Fatalf("update: %v\n%s", err, out)
+	}
+	if strings.Contains(out, "aborted") || !strings.Contains(out, "ran") {
+		t.Errorf("commands did not run without a terminal:\n%s", out)
+	}
+}
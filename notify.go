@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/shuymn-sandbox/update/updater"
+	"golang.org/x/sync/errgroup"
+)
+
+// notifiers are tried in order; the first one found in PATH is used.
+var notifiers = []func(msg string) updater.Command{
+	func(msg string) updater.Command {
+		return updater.Command{Name: "terminal-notifier", Args: []string{"-title", "update", "-message", msg}}
+	},
+	func(msg string) updater.Command {
+		return updater.Command{Name: "notify-send", Args: []string{"update", msg}}
+	},
+}
+
+// notify reports the outcome of the run with a desktop notification, or a
+// terminal bell when no notifier is installed. Failures are ignored.
+func notify(opts *updater.Options, succeeded, failed int) {
+	msg := fmt.Sprintf("%d succeeded, %d failed", succeeded, failed)
+	r := opts.Runner
+	for _, n := range notifiers {
+		c := n(msg)
+		if !c.Available(r) {
+			continue
+		}
+		proc, err := r.Start(context.Background(), &c, updater.StartOptions{})
+		if err != nil {
+			return
+		}
+		var eg errgroup.Group
+		for _, rd := range []io.Reader{proc.Stdout(), proc.Stderr()} {
+			rd := rd
+			eg.Go(func() error {
+				_, err := io.Copy(ioutil.Discard, rd)
+				return err
+			})
+		}
+		eg.Wait()
+		proc.Wait()
+		return
+	}
+
+	if updater.IsTerminal(os.Stdout) {
+		fmt.Fprint(os.Stdout, "\a")
+	}
+}
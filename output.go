@@ -0,0 +1,265 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuymn-sandbox/update/updater"
+)
+
+type jsonResult struct {
+	Name       string   `json:"name"`
+	ID         string   `json:"id"`
+	Args       []string `json:"args"`
+	Shell      string   `json:"shell,omitempty"`
+	Host       string   `json:"host"`
+	Status     string   `json:"status"`
+	ExitCode   int      `json:"exit_code"`
+	DurationMS int64    `json:"duration_ms"`
+	Stdout     string   `json:"stdout"`
+	Stderr     string   `json:"stderr"`
+	Error      string   `json:"error"`
+}
+
+// jsonTotal is written after the results of the commands.
+type jsonTotal struct {
+	TotalDurationMS int64 `json:"total_duration_ms"`
+	Commands        int   `json:"commands"`
+	OK              int   `json:"ok"`
+	Failed          int   `json:"failed"`
+	Skipped         int   `json:"skipped"`
+}
+
+// jsonWriter writes one JSON object per line. Results arrive from several
+// goroutines, so each object is encoded under a lock.
+type jsonWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonWriter{enc: enc}
+}
+
+func newJSONResult(r *updater.Result) jsonResult {
+	v := jsonResult{
+		Name:       r.Name,
+		ID:         r.ID,
+		Args:       r.Args,
+		Shell:      r.Shell,
+		Host:       r.Host,
+		Status:     r.Status.String(),
+		ExitCode:   r.ExitCode,
+		DurationMS: r.Duration.Milliseconds(),
+		Stdout:     r.Stdout,
+		Stderr:     r.Stderr,
+	}
+	if v.Args == nil {
+		v.Args = []string{}
+	}
+	if r.Err != nil {
+		v.Error = r.Err.Error()
+	}
+	return v
+}
+
+func (w *jsonWriter) write(r *updater.Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(newJSONResult(r))
+}
+
+func (w *jsonWriter) writeTotal(elapsed time.Duration, n int, t tally) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(jsonTotal{
+		TotalDurationMS: elapsed.Milliseconds(),
+		Commands:        n,
+		OK:              t.OK,
+		Failed:          t.Failed,
+		Skipped:         t.Skipped,
+	})
+}
+
+// jsonReport is the content of the -report file.
+type jsonReport struct {
+	jsonTotal
+	// Pre holds the results of the pre hooks, so that a run stopped by one
+	// of them is not reported as having had nothing to do.
+	Pre     []jsonResult `json:"pre,omitempty"`
+	Results []jsonResult `json:"results"`
+}
+
+// marshalReport encodes the results of the pre hooks and of the commands,
+// with the totals of the latter, as one JSON document, as written by
+// -report and sent by -webhook.
+func marshalReport(pre, results []updater.Result, elapsed time.Duration) ([]byte, error) {
+	t := countResults(results)
+	report := jsonReport{
+		jsonTotal: jsonTotal{
+			TotalDurationMS: elapsed.Milliseconds(),
+			Commands:        len(results),
+			OK:              t.OK,
+			Failed:          t.Failed,
+			Skipped:         t.Skipped,
+		},
+		Results: make([]jsonResult, 0, len(results)),
+	}
+	for i := range pre {
+		report.Pre = append(report.Pre, newJSONResult(&pre[i]))
+	}
+	for i := range results {
+		report.Results = append(report.Results, newJSONResult(&results[i]))
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// logFile is the destination of -log-file. It is shared by every logger, so
+// writes are serialized, and color codes are dropped on the way in. A path
+// ending in .gz is written compressed. Once the file has grown past
+// maxSize, it is moved to the rotated path and a new one is started.
+type logFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	size    int64
+	f       *os.File
+	gz      *gzip.Writer
+}
+
+func openLogFile(path string, maxSize int64) (*logFile, error) {
+	l := &logFile{path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+	// Appending makes another gzip member, which readers join to the
+	// earlier ones.
+	if strings.HasSuffix(l.path, ".gz") {
+		l.gz = gzip.NewWriter(f)
+	}
+	return nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := []byte(updater.StripColor(string(p)))
+	if l.gz != nil {
+		// Flushing every write keeps the file readable while update is
+		// still running.
+		if _, err := l.gz.Write(b); err != nil {
+			return 0, err
+		}
+		if err := l.gz.Flush(); err != nil {
+			return 0, err
+		}
+		fi, err := l.f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		l.size = fi.Size()
+	} else {
+		n, err := l.f.Write(b)
+		l.size += int64(n)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if l.maxSize > 0 && l.size >= l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// rotatedPath returns where the previous log file is kept: update.log and
+// update.log.gz are both rotated to update.log.1.gz.
+func (l *logFile) rotatedPath() string {
+	return strings.TrimSuffix(l.path, ".gz") + ".1.gz"
+}
+
+// rotate replaces the rotated file with the current one, compressing it on
+// the way unless it already is, and opens a new current file.
+func (l *logFile) rotate() error {
+	if err := l.close(); err != nil {
+		return err
+	}
+	if l.gz != nil {
+		if err := os.Rename(l.path, l.rotatedPath()); err != nil {
+			return err
+		}
+	} else {
+		if err := compressFile(l.path, l.rotatedPath()); err != nil {
+			return err
+		}
+		if err := os.Remove(l.path); err != nil {
+			return err
+		}
+	}
+	return l.open()
+}
+
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (l *logFile) close() error {
+	if l.gz != nil {
+		if err := l.gz.Close(); err != nil {
+			l.f.Close()
+			return err
+		}
+	}
+	return l.f.Close()
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.close()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/shuymn-sandbox/update/updater"
+)
+
+// snapshot maps the ID of each command to a fingerprint of its config, as
+// of the last successful run under -since.
+type snapshot map[string]string
+
+func loadSnapshot(path string) (snapshot, error) {
+	s := make(snapshot)
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// fingerprint changes whenever anything in the config of c does.
+func fingerprint(c *updater.Command) string {
+	b, _ := json.Marshal(c)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// changed returns the commands that are not in s or whose config differs.
+func (s snapshot) changed(cmds []updater.Command) []updater.Command {
+	var changed []updater.Command
+	for i := range cmds {
+		if s[cmds[i].Ref()] != fingerprint(&cmds[i]) {
+			changed = append(changed, cmds[i])
+		}
+	}
+	return changed
+}
+
+// update records the current config of cmds, keeping the other entries.
+func (s snapshot) update(cmds []updater.Command) {
+	for i := range cmds {
+		s[cmds[i].Ref()] = fingerprint(&cmds[i])
+	}
+}
+
+func (s snapshot) save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shuymn-sandbox/update/updater"
+)
+
+// printSummary writes a table of results to w. With color the statuses are
+// colored by outcome.
+func printSummary(w io.Writer, results []updater.Result, color bool) {
+	// Every status cell, the header's included, gets an escape sequence of
+	// the same length, so that tabwriter still lines up the columns.
+	cell := func(s string, c int) string {
+		if !color {
+			return s
+		}
+		return updater.Colorize(s, c)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tCOMMAND\t%s\tDURATION\n", cell("STATUS", colorDefault))
+	for _, r := range results {
+		c := updater.Command{Name: r.Name, Args: r.Args, Shell: r.Shell, Host: r.Host}
+		status := r.Status.String()
+		if r.Status == updater.StatusFailed && startFailed(r.Err) {
+			status = "failed to start"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.ID, c.String(), cell(status, statusColor(r.Status)), updater.FormatDuration(r.Duration))
+	}
+	tw.Flush()
+}
+
+func statusColor(s updater.Status) int {
+	switch s {
+	case updater.StatusOK:
+		return colorGreen
+	case updater.StatusFailed:
+		return colorRed
+	case updater.StatusNotStarted, updater.StatusCancelled:
+		return colorDefault
+	}
+	return colorYellow
+}
+
+// tally counts the results by outcome. Commands that never started or were
+// cancelled by -fail-fast are in none of the counts.
+type tally struct {
+	OK, Failed, Skipped int
+}
+
+func countResults(results []updater.Result) tally {
+	var t tally
+	for _, r := range results {
+		switch r.Status {
+		case updater.StatusOK:
+			t.OK++
+		case updater.StatusFailed:
+			t.Failed++
+		case updater.StatusSkipped, updater.StatusSkippedPlatform, updater.StatusSkippedDependency, updater.StatusDisabled, updater.StatusSkippedRecent:
+			t.Skipped++
+		}
+	}
+	return t
+}
+
+func printTotal(w io.Writer, elapsed time.Duration, n int, t tally) {
+	fmt.Fprintf(w, "Total: %s across %d commands, %d ok / %d failed / %d skipped\n", updater.FormatDuration(elapsed), n, t.OK, t.Failed, t.Skipped)
+}
+
+// failures returns the errors of the failed commands in the order of
+// results, so that the report does not depend on which finished first.
+func failures(results []updater.Result) []ExecutionError {
+	var errs []ExecutionError
+	for _, r := range results {
+		if r.Status == updater.StatusFailed {
+			errs = append(errs, ExecutionError{Name: r.ID, Error: r.Err, StartFailed: startFailed(r.Err)})
+		}
+	}
+	return errs
+}
+
+func startFailed(err error) bool {
+	var se *updater.StartError
+	return errors.As(err, &se)
+}
+
+// printErrors dumps the error of every failed command, one block each. With
+// opts.Tail only the last lines of each error are shown.
+func printErrors(errs []ExecutionError, opts *updater.Options) {
+	logger := log.New(opts.Stderr, "", log.Lmsgprefix)
+	for _, err := range errs {
+		fmt.Fprint(opts.Stdout, "\n")
+		logger.SetPrefix(opts.Prefix(err.Name, ""))
+		msg := err.Error.Error()
+		var ee *updater.ExitError
+		var se *updater.StartError
+		switch {
+		case err.StartFailed && errors.As(err.Error, &se):
+			// A command that never ran has no exit status to show; what
+			// kept it from starting follows.
+			logger.Print("failed to start")
+			msg = se.Err.Error()
+		case errors.As(err.Error, &ee):
+			logger.Print(ee.Status())
+			// Without any output the message would only be the exit
+			// status again.
+			if err.Error == error(ee) && ee.Stdout == "" && ee.Stderr == "" {
+				continue
+			}
+		}
+		s := bufio.NewScanner(strings.NewReader(msg))
+		var lines []string
+		for s.Scan() {
+			lines = append(lines, s.Text())
+		}
+		if opts.Tail > 0 && len(lines) > opts.Tail {
+			logger.Printf("… (%d earlier lines omitted)", len(lines)-opts.Tail)
+			lines = lines[len(lines)-opts.Tail:]
+		}
+		for _, line := range lines {
+			logger.Print(line)
+		}
+
+		if s.Err() != nil {
+			fmt.Fprintf(opts.Stdout, "Scanner error: %q\n", s.Err())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RunCache remembers when each command last succeeded, for the commands
+// with a MinInterval.
+type RunCache struct {
+	path string
+	// Ignore makes every command look like it never ran, while still
+	// recording the new runs.
+	Ignore bool
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func LoadRunCache(path string) (*RunCache, error) {
+	c := &RunCache{path: path, last: make(map[string]time.Time)}
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c.last); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// recent reports whether cmd succeeded less than its MinInterval ago.
+func (c *RunCache) recent(cmd *Command) bool {
+	if c.Ignore || cmd.MinInterval <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last, ok := c.last[cacheKey(cmd)]
+	return ok && time.Since(last) < cmd.MinInterval
+}
+
+func (c *RunCache) record(cmd *Command, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last[cacheKey(cmd)] = t
+}
+
+// cacheKey identifies cmd in the state file in a readable way.
+func cacheKey(cmd *Command) string {
+	key := cmd.Name + " | " + cmd.String()
+	if cmd.Dir != "" {
+		key += " | " + cmd.Dir
+	}
+	return key
+}
+
+func (c *RunCache) Save() error {
+	c.mu.Lock()
+	b, err := json.MarshalIndent(c.last, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, append(b, '\n'), 0644)
+}
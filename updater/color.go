@@ -0,0 +1,70 @@
+package updater
+
+import (
+	"hash/fnv"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var prefixColors = []int{31, 32, 33, 34, 35, 36, 91, 92, 93, 94, 95, 96}
+
+// nameColor picks a color for name that stays the same across runs.
+func nameColor(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return prefixColors[h.Sum32()%uint32(len(prefixColors))]
+}
+
+// Colorize wraps s in the escape sequences of the given SGR color.
+func Colorize(s string, color int) string {
+	return "\x1b[" + strconv.Itoa(color) + "m" + s + "\x1b[0m"
+}
+
+// DefaultPrefixFormat is the template of -prefix-format.
+const DefaultPrefixFormat = "[{{.Name}}{{if .Stream}}:{{.Stream}}{{end}}] "
+
+// prefixData is what -prefix-format is executed with.
+type prefixData struct {
+	Name string
+	// Stream is "err" for lines from stderr and empty otherwise.
+	Stream string
+}
+
+// ParsePrefixFormat parses a -prefix-format template, and checks that it
+// can be executed.
+func ParsePrefixFormat(format string) (*template.Template, error) {
+	t, err := template.New("prefix").Parse(format)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(ioutil.Discard, prefixData{Name: "name", Stream: "err"}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Prefix returns the label put in front of every line logged for the
+// command called name. suffix distinguishes streams of the same command
+// while keeping its color.
+func (o *Options) Prefix(name, suffix string) string {
+	t := o.PrefixFormat
+	if t == nil {
+		t = defaultPrefixTemplate
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, prefixData{Name: name, Stream: strings.TrimPrefix(suffix, ":")}); err != nil {
+		return ""
+	}
+	p := b.String()
+
+	// Trailing spaces are left uncolored.
+	label := strings.TrimRight(p, " ")
+	if o.Color && label != "" {
+		p = Colorize(label, nameColor(name)) + p[len(label):]
+	}
+	return p
+}
+
+var defaultPrefixTemplate = template.Must(ParsePrefixFormat(DefaultPrefixFormat))
@@ -0,0 +1,709 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type Command struct {
+	Name string `yaml:"name"`
+	// ID identifies the command in references and output when several
+	// share a name. AssignIDs derives one when it is empty.
+	ID   string   `yaml:"id"`
+	Args []string `yaml:"args"`
+	// Dir is the working directory of the command; the current directory
+	// is used when it is empty.
+	Dir string `yaml:"dir"`
+	// Env holds variables added to the inherited environment. A variable
+	// that is already set is replaced, not appended to.
+	Env map[string]string `yaml:"env"`
+	// Shell is a command line run through the system shell. When it is set,
+	// Args are ignored and Name is only used to label the output.
+	Shell string `yaml:"shell"`
+	// Host runs the command on another machine as ssh host -- command. Dir
+	// and Env then apply on that machine.
+	Host string `yaml:"host"`
+	// OS limits the command to the listed values of runtime.GOOS. It runs
+	// everywhere when the list is empty.
+	OS []string `yaml:"os"`
+	// DependsOn names the commands that have to finish before this one
+	// starts. The command is skipped if any of them failed.
+	DependsOn []string `yaml:"depends_on"`
+	// AllowNonZeroExit treats any exit code as success, for commands that
+	// use it to signal something other than failure.
+	AllowNonZeroExit bool `yaml:"allow_non_zero_exit"`
+	// Timeout overrides -timeout for this command. Zero inherits it.
+	Timeout time.Duration `yaml:"timeout"`
+	// RetryOn limits -retries to these exit codes; any nonzero code is
+	// retried when it is empty.
+	RetryOn []int `yaml:"retry_on"`
+	// Priority orders the launch of parallel commands when -parallel limits
+	// how many run at once: higher ones start first, so that slow commands
+	// can be given a head start. Commands of equal priority keep their
+	// order.
+	Priority int `yaml:"priority"`
+	// MinInterval skips the command when it last succeeded less than this
+	// long ago. Zero runs it every time.
+	MinInterval time.Duration `yaml:"min_interval"`
+	// ExpectMatch fails the command, even when it exits with 0, unless its
+	// stdout or stderr matches. ExpectNoMatch fails it when either does.
+	// Only the output kept under MaxCapture is looked at.
+	ExpectMatch   *Pattern `yaml:"expect_match"`
+	ExpectNoMatch *Pattern `yaml:"expect_no_match"`
+	// OnSuccess and OnFailure run one at a time after the command, depending
+	// on its outcome. Their failures are reported but do not change it.
+	OnSuccess []Command `yaml:"on_success"`
+	OnFailure []Command `yaml:"on_failure"`
+	// QuietStdout and QuietStderr stop the respective output from being
+	// streamed. It is still kept, and shown in the error report when the
+	// command fails.
+	QuietStdout bool `yaml:"quiet_stdout"`
+	QuietStderr bool `yaml:"quiet_stderr"`
+	// Enabled set to false keeps the command in the config without running
+	// it. It is enabled when the field is absent.
+	Enabled *bool `yaml:"enabled"`
+
+	// autoID is the ID given by AssignIDs.
+	autoID string
+}
+
+// Ref returns what identifies c in references and output: its ID, the one
+// given by AssignIDs, or its name followed by @host when it runs remotely.
+func (c *Command) Ref() string {
+	switch {
+	case c.ID != "":
+		return c.ID
+	case c.autoID != "":
+		return c.autoID
+	}
+	return c.baseRef()
+}
+
+func (c *Command) baseRef() string {
+	if c.Host != "" {
+		return c.Name + "@" + c.Host
+	}
+	return c.Name
+}
+
+// AssignIDs gives the commands without an ID that share a name, and host,
+// one of their own: the first keeps the name and the next ones get #2, #3
+// and so on. It fails when two commands end up with the same Ref.
+func AssignIDs(cmds []Command) error {
+	count := make(map[string]int, len(cmds))
+	for i := range cmds {
+		if cmds[i].ID == "" {
+			count[cmds[i].baseRef()]++
+		}
+	}
+	seen := make(map[string]int, len(cmds))
+	for i := range cmds {
+		c := &cmds[i]
+		c.autoID = ""
+		base := c.baseRef()
+		if c.ID != "" || count[base] < 2 {
+			continue
+		}
+		if seen[base]++; seen[base] > 1 {
+			c.autoID = fmt.Sprintf("%s#%d", base, seen[base])
+		}
+	}
+
+	refs := make(map[string]bool, len(cmds))
+	for i := range cmds {
+		ref := cmds[i].Ref()
+		if refs[ref] {
+			return fmt.Errorf("id %q is used by more than one command", ref)
+		}
+		refs[ref] = true
+	}
+	return nil
+}
+
+func (c *Command) matchesAny(refs []string) bool {
+	for _, ref := range refs {
+		if c.matches(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether ref, as found in depends_on or a group, refers
+// to c: either by its name, which all the commands sharing it answer to,
+// or by its Ref.
+func (c *Command) matches(ref string) bool {
+	return ref == c.Name || ref == c.Ref()
+}
+
+func (c *Command) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+func (c *Command) Supported() bool {
+	if len(c.OS) == 0 {
+		return true
+	}
+	for _, goos := range c.OS {
+		if goos == runtime.GOOS {
+			return true
+		}
+	}
+	return false
+}
+
+// Argv returns the program and arguments that actually run for c.
+func (c *Command) Argv() (string, []string) {
+	if c.Host != "" {
+		return "ssh", []string{c.Host, "--", c.remoteLine()}
+	}
+	if c.Shell != "" {
+		return shellCommand(c.Shell)
+	}
+	return c.Name, c.Args
+}
+
+func (c *Command) Available(r Runner) bool {
+	name, _ := c.Argv()
+	_, err := r.LookPath(name)
+	return err == nil
+}
+
+// print passes every line read from rd to sink, without its terminator and
+// with opts.Redact applied. Lines end with \n, \r\n or a lone \r, as
+// written by progress bars. With opts.FlushInterval a partial line is
+// passed on once it has waited that long for the rest.
+func (c *Command) print(rd io.Reader, sink lineSink, opts *Options) error {
+	chunks := make(chan []byte)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := rd.Read(buf)
+			if n > 0 {
+				chunks <- buf[:n]
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	emit := func(b []byte) {
+		sink.line(opts.redact(string(b)))
+	}
+	var pending []byte
+	var flush <-chan time.Time
+	// After a partial line has been flushed, the terminator that ends it
+	// is dropped rather than making an empty line: any of them while
+	// flushed is set, only a \n while afterCR is.
+	var flushed, afterCR bool
+	for {
+		select {
+		case b, ok := <-chunks:
+			if !ok {
+				if len(pending) > 0 {
+					emit(bytes.TrimSuffix(pending, []byte("\r")))
+				}
+				if err := <-errc; err != io.EOF {
+					return err
+				}
+				return nil
+			}
+			switch {
+			case flushed && bytes.HasPrefix(b, []byte("\r\n")):
+				b = b[2:]
+			case (flushed || afterCR) && bytes.HasPrefix(b, []byte("\n")):
+				b = b[1:]
+			case flushed && bytes.HasPrefix(b, []byte("\r")):
+				b = b[1:]
+				afterCR = len(b) == 0
+				flushed = false
+				if afterCR {
+					continue
+				}
+			}
+			flushed, afterCR = false, false
+			// The pending text holds no terminator, bar a final \r, so it
+			// only needs to be scanned again when one arrives; scanning it
+			// for every chunk of a long line would take quadratic time.
+			scan := bytes.IndexAny(b, "\r\n") >= 0 || bytes.HasSuffix(pending, []byte("\r"))
+			pending = append(pending, b...)
+			if scan {
+				pending = append(pending[:0], splitLines(pending, emit)...)
+			}
+			if len(pending) == 0 {
+				flush = nil
+			} else if flush == nil && opts.FlushInterval > 0 {
+				flush = opts.afterFunc()(opts.FlushInterval)
+			}
+		case <-flush:
+			flush = nil
+			if len(pending) > 0 {
+				if bytes.HasSuffix(pending, []byte("\r")) {
+					afterCR = true
+				} else {
+					flushed = true
+				}
+				emit(bytes.TrimSuffix(pending, []byte("\r")))
+				pending = pending[:0]
+			}
+		}
+	}
+}
+
+// splitLines passes the complete lines of b to emit and returns what is
+// left. A \r at the very end is kept, since a \n may follow it.
+func splitLines(b []byte, emit func([]byte)) []byte {
+	for {
+		i := bytes.IndexAny(b, "\r\n")
+		if i < 0 {
+			return b
+		}
+		end := i + 1
+		if b[i] == '\r' {
+			if end == len(b) {
+				return b
+			}
+			if b[end] == '\n' {
+				end++
+			}
+		}
+		emit(b[:i])
+		b = b[end:]
+	}
+}
+
+// truncateLine shortens s to n characters, ending it with an ellipsis
+// when anything was cut.
+func truncateLine(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n < 1 {
+		return "…"
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
+
+// freshEnv lists the variables of the current process that are kept with
+// FreshEnv.
+var freshEnv = []string{"PATH", "HOME"}
+
+// environ returns the environment of the command: the current process's
+// environment with Env applied on top. With fresh only the variables in
+// freshEnv are taken from the current process. The Env of a remote command
+// is set on its host instead.
+func (c *Command) environ(fresh bool) []string {
+	env := os.Environ()
+	if fresh {
+		env = make([]string, 0, len(freshEnv)+len(c.Env))
+		for _, k := range freshEnv {
+			if v, ok := os.LookupEnv(k); ok {
+				env = append(env, k+"="+v)
+			}
+		}
+	}
+	if len(c.Env) == 0 || c.Host != "" {
+		return env
+	}
+
+	merged := make([]string, 0, len(env)+len(c.Env))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			if _, ok := c.Env[kv[:i]]; ok {
+				continue
+			}
+		}
+		merged = append(merged, kv)
+	}
+
+	keys := make([]string, 0, len(c.Env))
+	for k := range c.Env {
+		keys = append(keys, k)
+	}
+	for _, k := range sortedStrings(keys) {
+		merged = append(merged, k+"="+c.Env[k])
+	}
+	return merged
+}
+
+func sortedStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
+
+// retries reports whether exiting with code makes c eligible for a retry.
+func (c *Command) retries(code int) bool {
+	if code <= 0 {
+		return false
+	}
+	if len(c.RetryOn) == 0 {
+		return true
+	}
+	for _, r := range c.RetryOn {
+		if r == code {
+			return true
+		}
+	}
+	return false
+}
+
+// needsSubcommand holds the programs commonly run by update that do
+// nothing useful without a subcommand.
+var needsSubcommand = map[string]bool{
+	"apt":     true,
+	"apt-get": true,
+	"brew":    true,
+	"cargo":   true,
+	"dnf":     true,
+	"flatpak": true,
+	"gem":     true,
+	"go":      true,
+	"mas":     true,
+	"npm":     true,
+	"pip":     true,
+	"pip3":    true,
+	"pipx":    true,
+	"rustup":  true,
+	"snap":    true,
+	"yarn":    true,
+}
+
+func (c *Command) String() string {
+	if c.Host != "" {
+		return "ssh " + ShellQuote(c.Host) + " -- " + c.remoteLine()
+	}
+	return c.line()
+}
+
+// line returns the shell command line of c, ignoring Host.
+func (c *Command) line() string {
+	if c.Shell != "" {
+		return c.Shell
+	}
+	words := make([]string, 0, len(c.Args)+1)
+	for _, w := range append([]string{c.Name}, c.Args...) {
+		words = append(words, ShellQuote(w))
+	}
+	return strings.Join(words, " ")
+}
+
+// remoteLine returns the command line run by ssh on Host, which changes to
+// Dir and sets Env first. A Shell line is run by sh, so that the variables
+// apply to all of it.
+func (c *Command) remoteLine() string {
+	var b strings.Builder
+	if c.Dir != "" {
+		b.WriteString("cd " + ShellQuote(c.Dir) + " && ")
+	}
+	keys := make([]string, 0, len(c.Env))
+	for k := range c.Env {
+		keys = append(keys, k)
+	}
+	for _, k := range sortedStrings(keys) {
+		b.WriteString(k + "=" + ShellQuote(c.Env[k]) + " ")
+	}
+	if c.Shell != "" {
+		b.WriteString("sh -c " + ShellQuote(c.Shell))
+	} else {
+		b.WriteString(c.line())
+	}
+	return b.String()
+}
+
+func (c *Command) execute(ctx context.Context, opts *Options) (*Result, error) {
+	var res *Result
+	var err error
+	start := time.Now()
+
+	// In group mode everything logged for the command is held back and
+	// flushed as one block once it has finished.
+	stdout, stderr := opts.stdout(), opts.stderr()
+	var group *syncBuffer
+	if opts.Group && opts.Format == FormatText {
+		group = &syncBuffer{}
+		stdout, stderr = group, group
+	}
+
+	// Only commands that ran and exited with a nonzero code are retried;
+	// a missing binary, a timeout or an interruption would fail again.
+retry:
+	for attempt := 1; ; attempt++ {
+		res = &Result{Name: c.Name, ID: c.Ref(), Args: c.Args, Shell: c.Shell, Host: c.Host, ExitCode: -1, Attempts: attempt}
+		err = c.run(ctx, opts, res, stdout, stderr)
+		if err == nil || !c.retries(res.ExitCode) || errors.As(err, new(*TimeoutError)) || ctx.Err() != nil || attempt > opts.Retries {
+			break
+		}
+
+		if opts.Logs(VerbosityBrief) {
+			log.New(stderr, opts.Prefix(c.Ref(), ""), log.Lmsgprefix).Printf("attempt %d exited with code %d, retrying in %v", attempt, res.ExitCode, opts.RetryDelay)
+		}
+		select {
+		case <-time.After(opts.RetryDelay):
+		case <-ctx.Done():
+			break retry
+		}
+	}
+	if err != nil && res.Attempts > 1 {
+		err = fmt.Errorf("gave up after %d attempts: %w", res.Attempts, err)
+	}
+
+	res.Duration = time.Since(start)
+	res.Err = err
+
+	switch {
+	case errors.As(err, new(*NotFoundError)) && !opts.Strict:
+		res.Status = StatusSkipped
+	case err != nil:
+		res.Status = StatusFailed
+	default:
+		res.Status = StatusOK
+	}
+
+	if res.Status != StatusSkipped && opts.Verbosity == VerbosityBrief && !opts.DryRun && opts.Format == FormatText {
+		log.New(stdout, opts.Prefix(c.Ref(), ""), log.Lmsgprefix).Printf("finished: %s in %s", res.Status, FormatDuration(res.Duration))
+	}
+	if group != nil {
+		group.flush(opts.stdout(), "==> "+c.Ref())
+	}
+	return res, err
+}
+
+func (c *Command) run(ctx context.Context, opts *Options, res *Result, stdout, stderr io.Writer) error {
+	prefix := opts.Prefix(c.Ref(), "")
+	runner := opts.runner()
+
+	if !c.Available(runner) {
+		name, _ := c.Argv()
+		err := &NotFoundError{Name: name}
+		if !opts.Strict && opts.Logs(VerbosityBrief) {
+			log.New(stdout, prefix, log.Lmsgprefix).Print("skipped: " + err.Error())
+		}
+		return err
+	}
+
+	if c.Dir != "" && c.Host == "" {
+		fi, err := os.Stat(c.Dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("working directory %s does not exist", c.Dir)
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("working directory %s is not a directory", c.Dir)
+		}
+	}
+
+	if opts.DryRun {
+		l := log.New(stdout, prefix, log.Lmsgprefix)
+		if c.Shell == "" && len(c.Args) == 0 && needsSubcommand[c.Name] {
+			l.Printf("warning: %s usually needs a subcommand, but no args are given", c.Name)
+		}
+		msg := "would run: " + c.String()
+		if c.Dir != "" {
+			msg += " (in " + c.Dir + ")"
+		}
+		l.Print(msg)
+		return nil
+	}
+
+	parent := ctx
+	timeout := opts.Timeout
+	if c.Timeout > 0 {
+		timeout = c.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if opts.EchoCommands {
+		log.New(stderr, prefix, log.Lmsgprefix).Print("+ " + c.String())
+	}
+	started := time.Now()
+	proc, err := runner.Start(ctx, c, StartOptions{Stdin: opts.Stdin, Grace: opts.Grace, FreshEnv: opts.FreshEnv})
+	if err != nil {
+		return &StartError{Name: c.Ref(), Err: err}
+	}
+	if opts.EchoCommands || opts.Logs(VerbosityDebug) {
+		log.New(stderr, prefix, log.Lmsgprefix).Printf("pid=%d", proc.Pid())
+	}
+
+	if opts.Verbosity == VerbosityBrief && opts.Format == FormatText {
+		log.New(stdout, prefix, log.Lmsgprefix).Print("started")
+	}
+	if opts.Progress != nil {
+		e := opts.Progress.start(c.Ref())
+		defer opts.Progress.finish(e)
+	}
+
+	var eg errgroup.Group
+	stdoutBuf := &captureBuffer{max: opts.MaxCapture}
+	stderrBuf := &captureBuffer{max: opts.MaxCapture}
+
+	// Both pipes have to reach EOF before Wait is called, as os/exec
+	// requires: a reader that gives up early would leave the process
+	// blocked on a full pipe and Wait hanging. drained makes sure whatever
+	// the reader left behind is discarded.
+	var outputBytes int64
+	drained := func(rd io.Reader, read func(io.Reader) error) func() error {
+		rd = &countingReader{r: rd, n: &outputBytes}
+		return func() error {
+			err := read(rd)
+			if _, derr := io.Copy(ioutil.Discard, rd); err == nil {
+				err = derr
+			}
+			return err
+		}
+	}
+
+	// In JSON mode nothing is streamed; both outputs end up in the record.
+	// JSONL streams them as well.
+	eg.Go(drained(proc.Stdout(), func(rd io.Reader) error {
+		switch {
+		case opts.Format == FormatJSON:
+			_, err := io.Copy(stdoutBuf, rd)
+			return err
+		case opts.Format == FormatJSONL:
+			return c.print(io.TeeReader(rd, stdoutBuf), newJSONLSink(opts.stdout(), c.Ref(), "stdout"), opts)
+		case opts.Verbosity < VerbosityNormal || c.QuietStdout:
+			if c.expects() || c.QuietStdout {
+				_, err := io.Copy(stdoutBuf, rd)
+				return err
+			}
+			return nil
+		}
+		if c.expects() {
+			rd = io.TeeReader(rd, stdoutBuf)
+		}
+		return c.print(rd, newTextSink(stdout, prefix, opts, started), opts)
+	}))
+
+	// stderr is informational; it is streamed under its own prefix and kept
+	// so that a failing command can report what it printed there.
+	eg.Go(drained(proc.Stderr(), func(rd io.Reader) error {
+		switch {
+		case opts.Format == FormatJSONL:
+			return c.print(io.TeeReader(rd, stderrBuf), newJSONLSink(opts.stdout(), c.Ref(), "stderr"), opts)
+		case opts.Format == FormatJSON || opts.Verbosity == VerbosityQuiet || c.QuietStderr:
+			_, err := io.Copy(stderrBuf, rd)
+			return err
+		}
+		return c.print(io.TeeReader(rd, stderrBuf), newTextSink(stderr, opts.Prefix(c.Ref(), ":err"), opts, started), opts)
+	}))
+
+	egErr := eg.Wait()
+	waitErr := proc.Wait()
+
+	res.ExitCode = proc.ExitCode()
+	res.Stdout = opts.redact(stdoutBuf.String())
+	res.Stderr = opts.redact(stderrBuf.String())
+
+	var stopErr error
+	switch {
+	case errors.Is(parent.Err(), context.DeadlineExceeded):
+		stopErr = &TimeoutError{Total: true}
+	case errors.Is(parent.Err(), context.Canceled):
+		stopErr = errInterrupted
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		stopErr = &TimeoutError{Timeout: timeout}
+	}
+	if stopErr != nil {
+		if proc.Killed() {
+			stopErr = fmt.Errorf("%w, killed after not exiting within %v of SIGTERM", stopErr, opts.Grace)
+		}
+		return c.withQuietOutput(stopErr, res)
+	}
+	// A command allowed to exit nonzero still fails when it could not be
+	// waited for or was killed by a signal, which leaves no exit code.
+	if waitErr != nil && c.AllowNonZeroExit && res.ExitCode > 0 {
+		waitErr = nil
+	}
+	if waitErr != nil {
+		return &ExitError{Code: res.ExitCode, Signal: exitSignal(waitErr), Stdout: c.quietStdout(res), Stderr: res.Stderr, Err: waitErr}
+	}
+	if opts.FailOnStderr && stderrBuf.Len() > 0 {
+		return &ExitError{Code: res.ExitCode, Stdout: c.quietStdout(res), Stderr: res.Stderr, Err: errWroteStderr}
+	}
+	if err := c.checkOutput(stdoutBuf.String(), stderrBuf.String(), opts); err != nil {
+		return c.withQuietOutput(err, res)
+	}
+	if elapsed := time.Since(started); opts.MinDuration > 0 && elapsed < opts.MinDuration && atomic.LoadInt64(&outputBytes) == 0 && opts.Logs(VerbosityBrief) {
+		log.New(stderr, prefix, log.Lmsgprefix).Printf("warning: finished in %s without any output, possibly a no-op", FormatDuration(elapsed))
+	}
+	return egErr
+}
+
+// quietStdout returns the stdout of res when QuietStdout kept it from
+// being seen.
+func (c *Command) quietStdout(res *Result) string {
+	if !c.QuietStdout {
+		return ""
+	}
+	return res.Stdout
+}
+
+// withQuietOutput attaches to err the output of res that QuietStdout and
+// QuietStderr kept from being seen, if any.
+func (c *Command) withQuietOutput(err error, res *Result) error {
+	oe := &OutputError{Stdout: c.quietStdout(res), Err: err}
+	if c.QuietStderr {
+		oe.Stderr = res.Stderr
+	}
+	if oe.Stdout == "" && oe.Stderr == "" {
+		return err
+	}
+	return oe
+}
+
+func (c *Command) expects() bool {
+	return c.ExpectMatch != nil || c.ExpectNoMatch != nil
+}
+
+// checkOutput checks stdout and stderr against ExpectMatch and
+// ExpectNoMatch.
+func (c *Command) checkOutput(stdout, stderr string, opts *Options) error {
+	if p := c.ExpectMatch; p != nil && !p.MatchString(stdout) && !p.MatchString(stderr) {
+		return &ExpectError{Pattern: p.String()}
+	}
+	if p := c.ExpectNoMatch; p != nil {
+		for _, out := range []string{stdout, stderr} {
+			if m := p.FindString(out); m != "" {
+				return &ExpectError{Pattern: p.String(), Match: opts.redact(m)}
+			}
+		}
+	}
+	return nil
+}
+
+// countingReader adds the number of bytes read through it to n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
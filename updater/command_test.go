@@ -0,0 +1,266 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// collectSink records the lines passed to it.
+type collectSink struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (s *collectSink) line(row string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, row)
+}
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+func TestCommandPrint(t *testing.T) {
+	long := strings.Repeat("x", 40*1024)
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"empty", "", nil},
+		{"single line", "hello\n", []string{"hello"}},
+		{"no trailing newline", "hello", []string{"hello"}},
+		{"multiple lines", "a\nb\nc\n", []string{"a", "b", "c"}},
+		{"multiple lines without trailing newline", "a\nb\nc", []string{"a", "b", "c"}},
+		{"empty lines", "\n\na\n\n", []string{"", "", "a", ""}},
+		{"crlf", "a\r\nb\r\n", []string{"a", "b"}},
+		{"lone cr", "10%\r50%\r100%\n", []string{"10%", "50%", "100%"}},
+		{"trailing cr", "a\r", []string{"a"}},
+		{"embedded nul", "a\x00b\n\x00\n", []string{"a\x00b", "\x00"}},
+		{"very long line", long + "\nend", []string{long, "end"}},
+	}
+	for _, tt := range tests {
+		readers := map[string]io.Reader{
+			"whole":    strings.NewReader(tt.input),
+			"one byte": iotest.OneByteReader(strings.NewReader(tt.input)),
+			"data EOF": iotest.DataErrReader(strings.NewReader(tt.input)),
+		}
+		for kind, rd := range readers {
+			t.Run(tt.name+"/"+kind, func(t *testing.T) {
+				var sink collectSink
+				c := &Command{Name: "test"}
+				if err := c.print(rd, &sink, &Options{}); err != nil {
+					t.Fatalf("print: %v", err)
+				}
+				if !reflect.DeepEqual(sink.lines, tt.want) {
+					t.Errorf("lines = %q, want %q", sink.lines, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestCommandPrintReadError(t *testing.T) {
+	var sink collectSink
+	c := &Command{Name: "test"}
+	errRead := errors.New("read failed")
+	rd := io.MultiReader(strings.NewReader("a\npartial"), errReader{errRead})
+	if err := c.print(rd, &sink, &Options{}); err != errRead {
+		t.Errorf("err = %v, want %v", err, errRead)
+	}
+	if want := []string{"a", "partial"}; !reflect.DeepEqual(sink.lines, want) {
+		t.Errorf("lines = %q, want %q", sink.lines, want)
+	}
+}
+
+func TestCommandPrintRedact(t *testing.T) {
+	var sink collectSink
+	c := &Command{Name: "test"}
+	opts := &Options{Redact: []*regexp.Regexp{regexp.MustCompile(`token=\w+`)}}
+	if err := c.print(strings.NewReader("token=abc ok\n"), &sink, opts); err != nil {
+		t.Fatalf("print: %v", err)
+	}
+	if want := []string{"*** ok"}; !reflect.DeepEqual(sink.lines, want) {
+		t.Errorf("lines = %q, want %q", sink.lines, want)
+	}
+}
+
+func TestCommandPrintFlushInterval(t *testing.T) {
+	// flush stands for the flush interval running out after the writes
+	// before it.
+	const flush = ""
+	tests := []struct {
+		name  string
+		steps []string
+		want  []string
+	}{
+		{"continued", []string{"Downloading...", flush, " done\nnext\n"}, []string{"Downloading...", " done", "next"}},
+		{"newline", []string{"Downloading...", flush, "\n"}, []string{"Downloading..."}},
+		{"crlf", []string{"Downloading...", flush, "\r\nnext\n"}, []string{"Downloading...", "next"}},
+		{"cr", []string{"10%", flush, "\r50%", flush, "\n"}, []string{"10%", "50%"}},
+		{"split crlf", []string{"Downloading...", flush, "\r", "\nnext\n"}, []string{"Downloading...", "next"}},
+		{"trailing cr", []string{"Downloading...\r", flush, "\nnext\n"}, []string{"Downloading...", "next"}},
+		{"blank line after", []string{"Downloading...", flush, "\n\nnext\n"}, []string{"Downloading...", "", "next"}},
+		{"not flushed", []string{"Downloading...", " done\n"}, []string{"Downloading... done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every timer print starts is handed over to be fired by a
+			// flush step, so nothing depends on how fast print runs.
+			timers := make(chan chan time.Time, len(tt.steps))
+			opts := &Options{FlushInterval: time.Second, after: func(time.Duration) <-chan time.Time {
+				c := make(chan time.Time)
+				timers <- c
+				return c
+			}}
+
+			rd, w := io.Pipe()
+			var sink collectSink
+			c := &Command{Name: "test"}
+			done := make(chan error, 1)
+			go func() {
+				done <- c.print(rd, &sink, opts)
+			}()
+
+			for _, step := range tt.steps {
+				if step == flush {
+					(<-timers) <- time.Time{}
+					continue
+				}
+				w.Write([]byte(step))
+			}
+			w.Close()
+			if err := <-done; err != nil {
+				t.Fatalf("print: %v", err)
+			}
+			if !reflect.DeepEqual(sink.lines, tt.want) {
+				t.Errorf("lines = %q, want %q", sink.lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandQuietOutput(t *testing.T) {
+	runner := fakeRunner{procs: map[string]fakeSpec{
+		"fail": {stdout: "held\n", stderr: "oops\n", code: 1},
+		"hang": {stdout: "held\n", stderr: "oops\n", hang: true},
+		"ok":   {stdout: "held\n", stderr: "oops\n"},
+	}}
+	tests := []struct {
+		name  string
+		c     Command
+		check func(error) bool
+	}{
+		{"exit", Command{Name: "fail"}, func(err error) bool { return errors.As(err, new(*ExitError)) }},
+		{"timeout", Command{Name: "hang", Timeout: 10 * time.Millisecond}, func(err error) bool { return errors.As(err, new(*TimeoutError)) }},
+		{"expect_match", Command{Name: "ok", ExpectMatch: &Pattern{regexp.MustCompile("done")}}, func(err error) bool { return errors.As(err, new(*ExpectError)) }},
+		{"expect_no_match", Command{Name: "ok", ExpectNoMatch: &Pattern{regexp.MustCompile("oops")}}, func(err error) bool { return errors.As(err, new(*ExpectError)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr lockedBuffer
+			tt.c.QuietStdout = true
+			tt.c.QuietStderr = true
+			opts := Options{Runner: runner, Stdout: &stdout, Stderr: &stderr}
+			results, err := Run(context.Background(), []Command{tt.c}, opts)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			r := results[0]
+			if r.Status != StatusFailed || !tt.check(r.Err) {
+				t.Fatalf("status = %v, err = %v", r.Status, r.Err)
+			}
+			if msg := r.Err.Error(); !strings.Contains(msg, "held") || !strings.Contains(msg, "oops") {
+				t.Errorf("error %q lacks the held back output", msg)
+			}
+			if out := stdout.String() + stderr.String(); strings.Contains(out, "held") || strings.Contains(out, "oops") {
+				t.Errorf("held back output was printed: %q", out)
+			}
+		})
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		input string
+		lines []string
+		rest  string
+	}{
+		{"", nil, ""},
+		{"abc", nil, "abc"},
+		{"a\nb", []string{"a"}, "b"},
+		{"a\r\nb\r\n", []string{"a", "b"}, ""},
+		{"a\rb", []string{"a"}, "b"},
+		{"a\r", nil, "a\r"},
+		{"\n\n", []string{"", ""}, ""},
+	}
+	for _, tt := range tests {
+		var lines []string
+		rest := splitLines([]byte(tt.input), func(b []byte) {
+			lines = append(lines, string(b))
+		})
+		if !reflect.DeepEqual(lines, tt.lines) || string(rest) != tt.rest {
+			t.Errorf("splitLines(%q) = %q, %q, want %q, %q", tt.input, lines, rest, tt.lines, tt.rest)
+		}
+	}
+}
+
+func TestTextSink(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxLogLine int
+		rows       []string
+		want       string
+	}{
+		{"plain", 0, []string{"a", "", "b"}, "[x] a\n[x] \n[x] b\n"},
+		{"truncated", 8, []string{"abcdef", "abc"}, "[x] abc…\n[x] abc\n"},
+		{"nul", 0, []string{"a\x00b"}, "[x] a\x00b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			s := newTextSink(&b, "[x] ", &Options{MaxLogLine: tt.maxLogLine}, time.Now())
+			for _, row := range tt.rows {
+				s.line(row)
+			}
+			if b.String() != tt.want {
+				t.Errorf("output = %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONLSink(t *testing.T) {
+	var b bytes.Buffer
+	s := newJSONLSink(&b, "brew", "stderr")
+	s.line("a <b>")
+	s.line("")
+
+	dec := json.NewDecoder(&b)
+	for _, want := range []string{"a <b>", ""} {
+		var l jsonLine
+		if err := dec.Decode(&l); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if l.Command != "brew" || l.Stream != "stderr" || l.Line != want || l.TS.IsZero() {
+			t.Errorf("line = %+v, want command brew, stream stderr and line %q", l, want)
+		}
+	}
+	if dec.More() {
+		t.Error("unexpected trailing lines")
+	}
+}
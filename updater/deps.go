@@ -0,0 +1,120 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dependencies returns, for each command, the indices of the commands it
+// depends on, as matched by Command.matches.
+func Dependencies(cmds []Command) [][]int {
+	deps := make([][]int, len(cmds))
+	for i, c := range cmds {
+		for _, ref := range c.DependsOn {
+			for j := range cmds {
+				if j != i && cmds[j].matches(ref) {
+					deps[i] = append(deps[i], j)
+				}
+			}
+		}
+	}
+	return deps
+}
+
+// ValidateDependencies reports references to unknown commands and cycles.
+func ValidateDependencies(cmds []Command) error {
+	for i, c := range cmds {
+		for _, ref := range c.DependsOn {
+			var matched []int
+			for j := range cmds {
+				if cmds[j].matches(ref) {
+					matched = append(matched, j)
+				}
+			}
+			switch {
+			case len(matched) == 0:
+				return fmt.Errorf("%s depends on unknown command %q", c.Ref(), ref)
+			case len(matched) == 1 && matched[0] == i:
+				return fmt.Errorf("%s depends on itself", c.Ref())
+			}
+		}
+	}
+
+	all := make([]int, len(cmds))
+	for i := range all {
+		all[i] = i
+	}
+	_, err := topoOrder(cmds, all, Dependencies(cmds))
+	return err
+}
+
+// topoOrder sorts indices so that every command comes after the commands it
+// depends on, keeping the listed order where dependencies allow it.
+// Dependencies outside of indices are considered settled.
+func topoOrder(cmds []Command, indices []int, deps [][]int) ([]int, error) {
+	remaining := make(map[int]bool, len(indices))
+	for _, i := range indices {
+		remaining[i] = true
+	}
+
+	order := make([]int, 0, len(indices))
+	for len(order) < len(indices) {
+		progressed := false
+		for _, i := range indices {
+			if !remaining[i] {
+				continue
+			}
+			ready := true
+			for _, d := range deps[i] {
+				if remaining[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				order = append(order, i)
+				delete(remaining, i)
+				progressed = true
+			}
+		}
+		if !progressed {
+			return nil, cycleError(cmds, indices, remaining, deps)
+		}
+	}
+	return order, nil
+}
+
+// cycleError describes one of the cycles among the remaining commands,
+// every one of which still depends on another remaining command.
+func cycleError(cmds []Command, indices []int, remaining map[int]bool, deps [][]int) error {
+	var start int
+	for _, i := range indices {
+		if remaining[i] {
+			start = i
+			break
+		}
+	}
+
+	seen := make(map[int]int)
+	var path []int
+	for i := start; ; {
+		if at, ok := seen[i]; ok {
+			path = append(path[at:], i)
+			break
+		}
+		seen[i] = len(path)
+		path = append(path, i)
+		for _, d := range deps[i] {
+			if remaining[d] {
+				i = d
+				break
+			}
+		}
+	}
+
+	names := make([]string, len(path))
+	for k, i := range path {
+		names[k] = cmds[i].Ref()
+	}
+	return fmt.Errorf("dependency cycle: %s", strings.Join(names, " -> "))
+}
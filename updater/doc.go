@@ -0,0 +1,18 @@
+// Package updater runs a list of commands, in parallel or one at a time,
+// and reports how each of them went. It is the engine of the update
+// command, which adds the config file and the command line on top.
+//
+// A minimal run of two commands, streaming their output to os.Stdout:
+//
+//	cmds := []updater.Command{
+//		{Name: "brew", Args: []string{"upgrade"}},
+//		{Name: "rustup", Args: []string{"self", "update"}},
+//	}
+//	results, err := updater.Run(context.Background(), cmds, updater.Options{Timeout: 10 * time.Minute})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	for _, r := range results {
+//		fmt.Printf("%s: %s\n", r.Name, r.Status)
+//	}
+package updater
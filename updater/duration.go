@@ -0,0 +1,19 @@
+package updater
+
+import "time"
+
+// FormatDuration formats d for the text output: to the second from a
+// minute up (2m13s), to the hundredth of a second from a second up (4.27s)
+// and to the millisecond below that (342ms), so that short commands do not
+// all show up as 0s.
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
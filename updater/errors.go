@@ -0,0 +1,160 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errInterrupted is returned for commands stopped by SIGINT or SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+// errCancelled is the error of the commands stopped by FailFast.
+var errCancelled = errors.New("cancelled after another command failed")
+
+// errWroteStderr is the cause of the ExitError returned under
+// -fail-on-stderr for commands that exited successfully.
+var errWroteStderr = errors.New("wrote to stderr")
+
+// ExitError is returned when a command ran and exited unsuccessfully. Code
+// is -1 when it was terminated by a signal, which Signal is then the number
+// of.
+type ExitError struct {
+	Code   int
+	Signal int
+	// Stdout is what the command printed on stdout when it was not
+	// streamed, and Stderr what it printed on stderr. Together they are
+	// used as the message when they are not empty.
+	Stdout string
+	Stderr string
+	Err    error
+}
+
+func (e *ExitError) Error() string {
+	switch {
+	case e.Stdout != "" && e.Stderr != "":
+		return strings.TrimSuffix(e.Stdout, "\n") + "\n" + e.Stderr
+	case e.Stdout != "":
+		return e.Stdout
+	case e.Stderr != "":
+		return e.Stderr
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// Status describes how the command ended, as in "exited with code 1" or
+// "killed by signal 15".
+func (e *ExitError) Status() string {
+	if e.Signal > 0 {
+		return fmt.Sprintf("killed by signal %d", e.Signal)
+	}
+	return fmt.Sprintf("exited with code %d", e.Code)
+}
+
+// OutputError is returned when a command held back by quiet_stdout or
+// quiet_stderr failed other than by exiting unsuccessfully, so that what it
+// printed follows the reason it failed.
+type OutputError struct {
+	Stdout string
+	Stderr string
+	Err    error
+}
+
+func (e *OutputError) Error() string {
+	msg := e.Err.Error()
+	for _, out := range []string{e.Stdout, e.Stderr} {
+		if out != "" {
+			msg += "\n" + strings.TrimSuffix(out, "\n")
+		}
+	}
+	return msg
+}
+
+func (e *OutputError) Unwrap() error { return e.Err }
+
+// exitSignal returns the signal that terminated the process waited for
+// with err, or 0.
+func exitSignal(err error) int {
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		return 0
+	}
+	if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return int(ws.Signal())
+	}
+	return 0
+}
+
+// NotFoundError is returned when the program of a command is not in PATH.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return "command not found in PATH"
+}
+
+// Is makes a NotFoundError match exec.ErrNotFound.
+func (e *NotFoundError) Is(target error) bool {
+	return target == exec.ErrNotFound
+}
+
+// TimeoutError is returned when a command was stopped by -timeout, its own
+// timeout or, when Total is set, -timeout-total.
+type TimeoutError struct {
+	Timeout time.Duration
+	Total   bool
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Total {
+		return "total timeout reached"
+	}
+	return fmt.Sprintf("timed out after %v", e.Timeout)
+}
+
+// Is makes a TimeoutError match context.DeadlineExceeded.
+func (e *TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// ExpectError is returned when the output of a command that exited
+// successfully does not meet its ExpectMatch or ExpectNoMatch.
+type ExpectError struct {
+	// Pattern is the expression that failed.
+	Pattern string
+	// Match is what ExpectNoMatch matched; it is empty for ExpectMatch.
+	Match string
+}
+
+func (e *ExpectError) Error() string {
+	if e.Match != "" {
+		return fmt.Sprintf("output matches expect_no_match %q: %q", e.Pattern, e.Match)
+	}
+	return fmt.Sprintf("output does not match expect_match %q", e.Pattern)
+}
+
+// StartError is returned when the process of a command could not be
+// started at all, as opposed to running and failing.
+type StartError struct {
+	Name string
+	Err  error
+}
+
+func (e *StartError) Error() string {
+	err := e.Err
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return fmt.Sprintf("failed to start %s: %v", e.Name, err)
+}
+
+func (e *StartError) Unwrap() error { return e.Err }
@@ -0,0 +1,159 @@
+package updater
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+type Options struct {
+	Parallel int
+	// Limits caps how many of some commands run at once. A command named
+	// by a limit obeys it instead of Parallel, and one named by several
+	// obeys all of them.
+	Limits []Limit
+	Serial bool
+	// Group holds back the output of each command and prints it as one
+	// block when the command finishes.
+	Group bool
+	// Ordered writes the blocks of Group in the order of the commands
+	// rather than in the order they finish.
+	Ordered bool
+	// FailFast stops the run as soon as a command fails.
+	FailFast bool
+	Timeout  time.Duration
+	// Grace is how long a command that timed out or was interrupted is
+	// given to exit after SIGTERM before it is killed.
+	Grace      time.Duration
+	Retries    int
+	RetryDelay time.Duration
+	DryRun     bool
+	// FailOnStderr fails commands that print anything on stderr, even when
+	// they exit successfully.
+	FailOnStderr bool
+	// EchoCommands prints every command line to stderr before it starts,
+	// whatever the verbosity.
+	EchoCommands bool
+	// FreshEnv runs the commands with only PATH, HOME and their own Env
+	// instead of inheriting the whole environment.
+	FreshEnv bool
+	// Strict reports commands missing from PATH as failures instead of
+	// skipping them.
+	Strict bool
+	// Format is FormatText, the default, FormatJSON, which captures the
+	// output instead of streaming it, or FormatJSONL.
+	Format    string
+	Verbosity Verbosity
+	// Color enables colored prefixes.
+	Color bool
+	// PrefixFormat is the template of the label put in front of each
+	// line logged for a command; defaultPrefixFormat is used when it is
+	// nil.
+	PrefixFormat *template.Template
+	// Timestamps selects the time put in front of each line of output.
+	Timestamps TimestampMode
+	// MaxLogLine is the width at which logged lines, prefix included, are
+	// cut short. Zero leaves them alone.
+	MaxLogLine int
+	// MinDuration warns about commands that succeed faster than this
+	// without printing anything, which often means they did nothing.
+	// Zero disables the warning.
+	MinDuration time.Duration
+	// FlushInterval is how long a partial line of output waits for the
+	// rest before it is logged anyway. Zero waits for the end of the line.
+	FlushInterval time.Duration
+	// Tail limits the error report of each failed command to its last
+	// lines. Zero shows everything.
+	Tail int
+	// MaxCapture is the number of bytes of each output kept in the result;
+	// the rest is discarded. Zero keeps everything.
+	MaxCapture int
+	// Redact lists patterns replaced with *** in the output of the
+	// commands, both as it is logged and as it is kept in the results.
+	Redact []*regexp.Regexp
+	// Stdin is forwarded to the commands. It should only be set when they
+	// run serially, since parallel commands would compete for the input.
+	Stdin io.Reader
+	// Stdout and Stderr receive everything that is logged; os.Stdout and
+	// os.Stderr are used when they are nil.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Runner starts the commands; os/exec is used when it is nil.
+	Runner Runner
+	// Progress, when set, shows a spinner for each running command. Stdout
+	// and Stderr should then be wrapped by it.
+	Progress *Progress
+	// Cache skips the commands that succeeded within their MinInterval and
+	// records the ones that succeed. MinInterval is ignored when it is nil.
+	Cache *RunCache
+	// Running, when set, is kept up to date with the commands currently
+	// executing.
+	Running *RunningSet
+	// OnResult is called with each result as soon as its command finishes,
+	// possibly from several goroutines at once.
+	OnResult func(*Result)
+
+	// hookDepth is the number of on_success and on_failure hooks the
+	// commands being run are nested in.
+	hookDepth int
+	// after replaces time.After in tests.
+	after func(time.Duration) <-chan time.Time
+}
+
+// Limit is the parallelism shared by a set of commands.
+type Limit struct {
+	// Names are the names or IDs of the commands sharing the limit.
+	Names []string
+	// Parallel is how many of them may run at once.
+	Parallel int
+}
+
+// Logs reports whether messages at level v are written.
+func (o *Options) Logs(v Verbosity) bool {
+	return o.Format == FormatText && o.Verbosity >= v
+}
+
+// Debugf logs a message about the tool itself under -verbose.
+func (o *Options) Debugf(format string, v ...interface{}) {
+	if o.Logs(VerbosityDebug) {
+		fmt.Fprintf(o.stderr(), "debug: "+format+"\n", v...)
+	}
+}
+
+func (o *Options) redact(s string) string {
+	for _, re := range o.Redact {
+		s = re.ReplaceAllString(s, "***")
+	}
+	return s
+}
+
+func (o *Options) stdout() io.Writer {
+	if o.Stdout != nil {
+		return o.Stdout
+	}
+	return os.Stdout
+}
+
+func (o *Options) stderr() io.Writer {
+	if o.Stderr != nil {
+		return o.Stderr
+	}
+	return os.Stderr
+}
+
+func (o *Options) afterFunc() func(time.Duration) <-chan time.Time {
+	if o.after != nil {
+		return o.after
+	}
+	return time.After
+}
+
+func (o *Options) runner() Runner {
+	if o.Runner != nil {
+		return o.Runner
+	}
+	return ExecRunner{}
+}
@@ -0,0 +1,144 @@
+package updater
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	// FormatJSONL streams every line of output as a JSON object, along
+	// with the results of FormatJSON.
+	FormatJSONL = "jsonl"
+)
+
+const truncatedMarker = "…(truncated)"
+
+// captureBuffer keeps the first max bytes written to it and silently drops
+// the rest, so that the pipe it is copied from is still drained.
+type captureBuffer struct {
+	max       int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.max > 0 {
+		if room := b.max - b.buf.Len(); len(p) > room {
+			p = p[:room]
+			b.truncated = true
+		}
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *captureBuffer) Len() int {
+	return b.buf.Len()
+}
+
+func (b *captureBuffer) String() string {
+	s := b.buf.String()
+	if b.truncated {
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		s += truncatedMarker
+	}
+	return s
+}
+
+// outputMu serializes writes of whole blocks to the terminal.
+var outputMu sync.Mutex
+
+// syncBuffer collects the output of one command, which is written by the
+// goroutines reading its stdout and stderr at the same time.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// flush writes header followed by the collected output to w as one block.
+func (b *syncBuffer) flush(w io.Writer, header string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	block := make([]byte, 0, len(header)+1+b.buf.Len())
+	block = append(block, header...)
+	block = append(block, '\n')
+	block = append(block, b.buf.Bytes()...)
+	b.buf.Reset()
+	_, err := w.Write(block)
+	return err
+}
+
+// orderedOutput holds one block of output per command and writes them to
+// w in order, each as soon as it and all the blocks before it are complete.
+type orderedOutput struct {
+	w      io.Writer
+	blocks []syncBuffer
+
+	mu   sync.Mutex
+	done []bool
+	next int
+}
+
+func newOrderedOutput(w io.Writer, n int) *orderedOutput {
+	return &orderedOutput{w: w, blocks: make([]syncBuffer, n), done: make([]bool, n)}
+}
+
+func (o *orderedOutput) block(i int) io.Writer {
+	return &o.blocks[i]
+}
+
+func (o *orderedOutput) complete(i int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.done[i] = true
+	for o.next < len(o.blocks) && o.done[o.next] {
+		o.write(o.next)
+		o.next++
+	}
+}
+
+// flushAll writes the blocks that are left, such as those of commands that
+// never ran because the run was stopped.
+func (o *orderedOutput) flushAll() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for ; o.next < len(o.blocks); o.next++ {
+		o.write(o.next)
+	}
+}
+
+func (o *orderedOutput) write(i int) {
+	b := &o.blocks[i]
+	b.mu.Lock()
+	block := append([]byte(nil), b.buf.Bytes()...)
+	b.buf.Reset()
+	b.mu.Unlock()
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	o.w.Write(block)
+}
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// StripColor removes the color codes from s.
+func StripColor(s string) string {
+	return ansiEscape.ReplaceAllString(s, "")
+}
@@ -0,0 +1,24 @@
+package updater
+
+import "regexp"
+
+// Pattern is a regular expression that can be read from a config.
+type Pattern struct {
+	*regexp.Regexp
+}
+
+func (p *Pattern) UnmarshalText(text []byte) error {
+	re, err := regexp.Compile(string(text))
+	if err != nil {
+		return err
+	}
+	p.Regexp = re
+	return nil
+}
+
+func (p Pattern) MarshalText() ([]byte, error) {
+	if p.Regexp == nil {
+		return nil, nil
+	}
+	return []byte(p.String()), nil
+}
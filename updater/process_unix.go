@@ -0,0 +1,66 @@
+//go:build !windows
+// +build !windows
+
+package updater
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// setProcessGroup makes cmd the leader of a new process group, so that
+// the processes it spawns can be signaled along with it. A process outside
+// the foreground process group is stopped when it reads from the terminal,
+// so when tty is the terminal it reads from, its group is put in the
+// foreground of tty for as long as it runs. It reports whether it did so.
+func setProcessGroup(cmd *exec.Cmd, tty *os.File) bool {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if tty == nil {
+		return false
+	}
+	// update itself running in the background must not take the terminal
+	// away from whatever is in the foreground.
+	var pgrp int32
+	if err := ioctl(tty, syscall.TIOCGPGRP, unsafe.Pointer(&pgrp)); err != nil || int(pgrp) != syscall.Getpgrp() {
+		return false
+	}
+	cmd.SysProcAttr.Foreground = true
+	cmd.SysProcAttr.Ctty = int(tty.Fd())
+	return true
+}
+
+// leaveForeground gives tty back to our process group once the command
+// put in the foreground by setProcessGroup has exited. A Ctrl-C only
+// reached the group of the command, so it is passed on to us as well.
+func leaveForeground(tty *os.File, state *os.ProcessState) {
+	// Changing the foreground group from the background raises SIGTTOU
+	// unless it is ignored.
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	pgrp := int32(syscall.Getpgrp())
+	ioctl(tty, syscall.TIOCSPGRP, unsafe.Pointer(&pgrp))
+
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() && ws.Signal() == syscall.SIGINT {
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}
+}
+
+// terminate asks the process group led by cmd to exit.
+func terminate(cmd *exec.Cmd) error {
+	return signalGroup(cmd, syscall.SIGTERM)
+}
+
+// kill kills the process group led by cmd.
+func kill(cmd *exec.Cmd) error {
+	return signalGroup(cmd, syscall.SIGKILL)
+}
+
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setpgid {
+		return cmd.Process.Signal(sig)
+	}
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
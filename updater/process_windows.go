@@ -0,0 +1,23 @@
+package updater
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing on Windows; only the process itself is
+// killed there.
+func setProcessGroup(cmd *exec.Cmd, tty *os.File) bool { return false }
+
+func leaveForeground(tty *os.File, state *os.ProcessState) {}
+
+// terminate fails on Windows, which cannot deliver SIGTERM, so that the
+// process is killed right away.
+func terminate(cmd *exec.Cmd) error {
+	return errors.New("not supported on windows")
+}
+
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,120 @@
+package updater
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var spinnerFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// Progress keeps one line per running command at the bottom of the
+// terminal, showing a spinner and the elapsed time. Everything else written
+// to the terminal has to go through Wrap so that the lines can be cleared
+// first and redrawn afterwards.
+type Progress struct {
+	mu      sync.Mutex
+	out     io.Writer
+	entries []*progressEntry
+	drawn   int
+	frame   int
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type progressEntry struct {
+	label string
+	start time.Time
+}
+
+func NewProgress(out io.Writer, interval time.Duration) *Progress {
+	p := &Progress{out: out, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.mu.Lock()
+				p.frame++
+				p.clear()
+				p.draw()
+				p.mu.Unlock()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *Progress) start(label string) *progressEntry {
+	e := &progressEntry{label: label, start: time.Now()}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.entries = append(p.entries, e)
+	p.draw()
+	return e
+}
+
+func (p *Progress) finish(e *progressEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	for i, x := range p.entries {
+		if x == e {
+			p.entries = append(p.entries[:i], p.entries[i+1:]...)
+			break
+		}
+	}
+	p.draw()
+}
+
+// Close stops the spinner and removes its lines from the terminal.
+func (p *Progress) Close() {
+	close(p.stop)
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.entries = nil
+}
+
+func (p *Progress) clear() {
+	if p.drawn > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.drawn)
+		p.drawn = 0
+	}
+}
+
+func (p *Progress) draw() {
+	frame := spinnerFrames[p.frame%len(spinnerFrames)]
+	for _, e := range p.entries {
+		d := time.Since(e.start)
+		fmt.Fprintf(p.out, "%c %s (%02d:%02d)\n", frame, e.label, int(d.Minutes()), int(d.Seconds())%60)
+	}
+	p.drawn = len(p.entries)
+}
+
+// Wrap returns a writer to w that keeps the spinner lines below whatever
+// is written.
+func (p *Progress) Wrap(w io.Writer) io.Writer {
+	return &progressWriter{p: p, w: w}
+}
+
+type progressWriter struct {
+	p *Progress
+	w io.Writer
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	pw.p.mu.Lock()
+	defer pw.p.mu.Unlock()
+	pw.p.clear()
+	n, err := pw.w.Write(b)
+	pw.p.draw()
+	return n, err
+}
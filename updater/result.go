@@ -0,0 +1,65 @@
+package updater
+
+import "time"
+
+type Status int
+
+const (
+	StatusNotStarted Status = iota
+	StatusOK
+	StatusFailed
+	StatusSkipped
+	StatusSkippedPlatform
+	StatusSkippedDependency
+	StatusDisabled
+	StatusSkippedRecent
+	// StatusCancelled is given to the commands stopped by FailFast because
+	// another one failed.
+	StatusCancelled
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusFailed:
+		return "failed"
+	case StatusSkipped:
+		return "skipped (unavailable)"
+	case StatusSkippedPlatform:
+		return "skipped (platform)"
+	case StatusSkippedDependency:
+		return "skipped (dependency failed)"
+	case StatusDisabled:
+		return "disabled"
+	case StatusSkippedRecent:
+		return "skipped (ran recently)"
+	case StatusCancelled:
+		return "cancelled"
+	default:
+		return "not started"
+	}
+}
+
+// Result describes a single execution of a Command. ExitCode is -1 when
+// the process never ran or was terminated by a signal.
+type Result struct {
+	Name string
+	// ID is the Ref of the command.
+	ID   string
+	Args []string
+	// Shell is the command line of a shell command, which Args are then
+	// ignored for.
+	Shell string
+	// Host is where the command ran; it is empty for this machine.
+	Host     string
+	Status   Status
+	ExitCode int
+	// Attempts is the number of times the command was run, including
+	// retries.
+	Attempts int
+	Duration time.Duration
+	Stdout   string
+	Stderr   string
+	Err      error
+}
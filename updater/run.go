@@ -0,0 +1,341 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var errFailFast = errors.New("a command failed")
+
+// maxHookDepth bounds how deeply on_success and on_failure hooks can be
+// nested, in case a config generates them endlessly.
+const maxHookDepth = 8
+
+// RunningSet tracks the commands that are currently executing.
+type RunningSet struct {
+	mu   sync.Mutex
+	cmds map[*Command]bool
+}
+
+func (s *RunningSet) add(c *Command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmds == nil {
+		s.cmds = make(map[*Command]bool)
+	}
+	s.cmds[c] = true
+}
+
+func (s *RunningSet) remove(c *Command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cmds, c)
+}
+
+func (s *RunningSet) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.cmds))
+	for c := range s.cmds {
+		names = append(names, c.Ref())
+	}
+	return sortedStrings(names)
+}
+
+// Run executes commands as configured by opts and returns their results in
+// the order of commands. An error is only returned when the dependencies of
+// commands form a cycle.
+func Run(ctx context.Context, commands []Command, opts Options) ([]Result, error) {
+	if opts.Format == "" {
+		opts.Format = FormatText
+	}
+	if opts.Running == nil {
+		opts.Running = &RunningSet{}
+	}
+	if opts.OnResult == nil {
+		opts.OnResult = func(*Result) {}
+	}
+	results, err := runCommands(ctx, commands, &opts)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]Result, len(results))
+	for i, r := range results {
+		values[i] = *r
+	}
+	return values, nil
+}
+
+func runCommands(ctx context.Context, cmds []Command, opts *Options) ([]*Result, error) {
+	parent := ctx
+	results := make([]*Result, len(cmds))
+	for i, cmd := range cmds {
+		results[i] = &Result{Name: cmd.Name, ID: cmd.Ref(), Args: cmd.Args, Shell: cmd.Shell, Host: cmd.Host, ExitCode: -1}
+	}
+
+	// finished[i] is closed once the command at i no longer needs to be
+	// waited for by the commands depending on it.
+	finished := make([]chan struct{}, len(cmds))
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+
+	// With -ordered every command logs into its own block, and the blocks
+	// are written in the order of cmds as they complete.
+	var ordered *orderedOutput
+	if opts.Ordered {
+		ordered = newOrderedOutput(opts.stdout(), len(cmds))
+		defer ordered.flushAll()
+	}
+	optsFor := func(i int) *Options {
+		if ordered == nil {
+			return opts
+		}
+		o := *opts
+		o.Stdout = ordered.block(i)
+		o.Stderr = ordered.block(i)
+		return &o
+	}
+	complete := func(i int, res *Result) {
+		opts.OnResult(res)
+		if ordered != nil {
+			ordered.complete(i)
+		}
+	}
+
+	// settle records the outcome of a command that is not going to run,
+	// logging msg when it is not empty.
+	settle := func(i int, status Status, msg string) {
+		close(finished[i])
+		results[i].Status = status
+		if msg != "" {
+			o := optsFor(i)
+			log.New(o.stdout(), o.Prefix(cmds[i].Ref(), ""), log.Lmsgprefix).Print(msg)
+		}
+		complete(i, results[i])
+	}
+
+	// Disabled commands, those that ran recently and those meant for
+	// other platforms are settled before anything runs.
+	pending := make([]int, 0, len(cmds))
+	for i := range cmds {
+		dryRunLog := opts.DryRun && opts.Format == FormatText
+		switch {
+		case !cmds[i].IsEnabled():
+			msg := ""
+			if dryRunLog {
+				msg = "skipped: disabled"
+			}
+			settle(i, StatusDisabled, msg)
+		case opts.Cache != nil && opts.Cache.recent(&cmds[i]):
+			msg := ""
+			if opts.Logs(VerbosityBrief) {
+				msg = fmt.Sprintf("skipped: succeeded less than %v ago", cmds[i].MinInterval)
+			}
+			settle(i, StatusSkippedRecent, msg)
+		case !cmds[i].Supported():
+			msg := ""
+			if dryRunLog {
+				msg = "skipped: only runs on " + strings.Join(cmds[i].OS, ", ")
+			}
+			settle(i, StatusSkippedPlatform, msg)
+		default:
+			pending = append(pending, i)
+		}
+	}
+
+	deps := Dependencies(cmds)
+	order, err := topoOrder(cmds, pending, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	run := func(ctx context.Context, i int) error {
+		defer close(finished[i])
+		for _, d := range deps[i] {
+			if s := results[d].Status; s == StatusFailed || s == StatusSkippedDependency {
+				results[i].Status = StatusSkippedDependency
+				if opts.DryRun && opts.Format == FormatText {
+					o := optsFor(i)
+					log.New(o.stdout(), o.Prefix(cmds[i].Ref(), ""), log.Lmsgprefix).Printf("skipped: depends on %s, which would not run", cmds[d].Ref())
+				}
+				complete(i, results[i])
+				return nil
+			}
+		}
+
+		opts.Running.add(&cmds[i])
+		defer opts.Running.remove(&cmds[i])
+		res, _ := cmds[i].execute(ctx, optsFor(i))
+		// Only FailFast cancels ctx without the caller having done so.
+		if errors.Is(res.Err, errInterrupted) && parent.Err() == nil {
+			res.Status = StatusCancelled
+			res.Err = errCancelled
+		}
+		results[i] = res
+		if opts.Cache != nil && res.Status == StatusOK && !opts.DryRun && cmds[i].MinInterval > 0 {
+			opts.Cache.record(&cmds[i], time.Now())
+		}
+		runHooks(ctx, &cmds[i], res, optsFor(i))
+		complete(i, res)
+		if opts.FailFast && res.Status == StatusFailed {
+			return errFailFast
+		}
+		return nil
+	}
+
+	if opts.Serial {
+		for _, i := range order {
+			if ctx.Err() != nil {
+				break
+			}
+			if err := run(ctx, i); err != nil {
+				break
+			}
+		}
+		return results, nil
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return cmds[order[a]].Priority > cmds[order[b]].Priority
+	})
+
+	// With -fail-fast the first failure cancels ctx, which stops the
+	// remaining commands from being launched and kills the running ones.
+	eg, ctx := errgroup.WithContext(ctx)
+
+	// The semaphore is acquired before spawning so that commands are
+	// started in the declared order even when the limit is reached.
+	var sem chan struct{}
+	if opts.Parallel > 0 {
+		sem = make(chan struct{}, opts.Parallel)
+	}
+
+	// slots[i] are the semaphores command i holds while it runs: those of
+	// the limits naming it, or the global one.
+	slots := make([][]chan struct{}, len(cmds))
+	limited := make([]bool, len(cmds))
+	for _, l := range opts.Limits {
+		if l.Parallel <= 0 {
+			continue
+		}
+		ls := make(chan struct{}, l.Parallel)
+		for i := range cmds {
+			if cmds[i].matchesAny(l.Names) {
+				slots[i] = append(slots[i], ls)
+				limited[i] = true
+			}
+		}
+	}
+	for i := range slots {
+		if !limited[i] && sem != nil {
+			slots[i] = []chan struct{}{sem}
+		}
+	}
+	// acquire takes the slots of command i, always in the same order so
+	// that commands sharing several limits cannot deadlock.
+	acquire := func(ctx context.Context, i int) bool {
+		for k, s := range slots[i] {
+			select {
+			case s <- struct{}{}:
+			case <-ctx.Done():
+				release(slots[i][:k])
+				return false
+			}
+		}
+		return true
+	}
+
+spawn:
+	for _, i := range order {
+		i := i
+
+		// A command with dependencies waits for them before taking a slot,
+		// so that it does not hold one while it cannot run. A command of a
+		// limited group waits on its own, so that it does not hold up the
+		// commands of other groups.
+		if len(deps[i]) > 0 || limited[i] {
+			eg.Go(func() error {
+				for _, d := range deps[i] {
+					select {
+					case <-finished[d]:
+					case <-ctx.Done():
+						return nil
+					}
+				}
+				if !acquire(ctx, i) {
+					return nil
+				}
+				defer release(slots[i])
+				return run(ctx, i)
+			})
+			continue
+		}
+
+		if !acquire(ctx, i) {
+			break spawn
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		eg.Go(func() error {
+			defer release(slots[i])
+			return run(ctx, i)
+		})
+	}
+	eg.Wait()
+
+	return results, nil
+}
+
+func release(sems []chan struct{}) {
+	for _, s := range sems {
+		<-s
+	}
+}
+
+// runHooks runs the on_success or on_failure hooks of c according to res,
+// and logs those that failed.
+func runHooks(ctx context.Context, c *Command, res *Result, opts *Options) {
+	var label string
+	var hooks []Command
+	switch res.Status {
+	case StatusOK:
+		label, hooks = "on_success", c.OnSuccess
+	case StatusFailed:
+		label, hooks = "on_failure", c.OnFailure
+	}
+	if len(hooks) == 0 || ctx.Err() != nil {
+		return
+	}
+
+	logger := log.New(opts.stderr(), opts.Prefix(c.Ref(), ""), log.Lmsgprefix)
+	if opts.hookDepth >= maxHookDepth {
+		logger.Printf("not running %s: hooks are nested more than %d deep", label, maxHookDepth)
+		return
+	}
+
+	hookOpts := *opts
+	hookOpts.Serial = true
+	hookOpts.FailFast = false
+	hookOpts.hookDepth++
+	hookOpts.OnResult = func(*Result) {}
+	results, err := runCommands(ctx, hooks, &hookOpts)
+	if err != nil {
+		logger.Printf("%s: %v", label, err)
+		return
+	}
+	for _, r := range results {
+		if r.Status == StatusFailed {
+			logger.Printf("%s hook %s failed: %v", label, r.ID, r.Err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRunner starts a fakeProcess for every command in procs and reports
+// the others as missing from PATH.
+type fakeRunner struct {
+	procs map[string]fakeSpec
+}
+
+// fakeSpec describes what a fake process prints and how it exits.
+type fakeSpec struct {
+	stdout string
+	stderr string
+	code   int
+	// hang keeps the process running until it is stopped.
+	hang bool
+}
+
+func (r fakeRunner) LookPath(file string) (string, error) {
+	if _, ok := r.procs[file]; !ok {
+		return "", exec.ErrNotFound
+	}
+	return "/fake/" + file, nil
+}
+
+func (r fakeRunner) Start(ctx context.Context, c *Command, so StartOptions) (Process, error) {
+	spec := r.procs[c.Name]
+	p := &fakeProcess{
+		stdout: strings.NewReader(spec.stdout),
+		stderr: strings.NewReader(spec.stderr),
+		code:   spec.code,
+	}
+	if spec.hang {
+		p.wait = func() { <-ctx.Done() }
+	}
+	return p, nil
+}
+
+type fakeProcess struct {
+	stdout io.Reader
+	stderr io.Reader
+	code   int
+	// wait, when set, is called by Wait before it returns.
+	wait func()
+}
+
+func (p *fakeProcess) Stdout() io.Reader { return p.stdout }
+func (p *fakeProcess) Stderr() io.Reader { return p.stderr }
+func (p *fakeProcess) Pid() int          { return 1 }
+func (p *fakeProcess) ExitCode() int     { return p.code }
+func (p *fakeProcess) Killed() bool      { return false }
+
+func (p *fakeProcess) Wait() error {
+	if p.wait != nil {
+		p.wait()
+	}
+	if p.code != 0 {
+		return fmt.Errorf("exit status %d", p.code)
+	}
+	return nil
+}
+
+// lockedBuffer is a bytes.Buffer that can be written to concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRunFakeRunner(t *testing.T) {
+	runner := fakeRunner{procs: map[string]fakeSpec{
+		"ok":   {stdout: "updated\n"},
+		"fail": {stdout: "partial\n", stderr: "broken\n", code: 2},
+	}}
+	tests := []struct {
+		name     string
+		strict   bool
+		status   Status
+		exitCode int
+		stdout   string
+		stderr   string
+		check    func(error) bool
+	}{
+		{
+			name:     "ok",
+			status:   StatusOK,
+			exitCode: 0,
+			stdout:   "[ok] updated\n",
+			check:    func(err error) bool { return err == nil },
+		},
+		{
+			name:     "fail",
+			status:   StatusFailed,
+			exitCode: 2,
+			stdout:   "[fail] partial\n",
+			stderr:   "[fail:err] broken\n",
+			check: func(err error) bool {
+				var ee *ExitError
+				return errors.As(err, &ee) && ee.Code == 2 && ee.Stderr == "broken\n"
+			},
+		},
+		{
+			name:     "missing",
+			status:   StatusSkipped,
+			exitCode: -1,
+			stdout:   "[missing] skipped: command not found in PATH\n",
+			check:    func(err error) bool { return errors.Is(err, exec.ErrNotFound) },
+		},
+		{
+			name:     "missing",
+			strict:   true,
+			status:   StatusFailed,
+			exitCode: -1,
+			check:    func(err error) bool { return errors.Is(err, exec.ErrNotFound) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/strict=%v", tt.name, tt.strict), func(t *testing.T) {
+			var stdout, stderr lockedBuffer
+			opts := Options{Runner: runner, Stdout: &stdout, Stderr: &stderr, Strict: tt.strict}
+			results, err := Run(context.Background(), []Command{{Name: tt.name}}, opts)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			r := results[0]
+			if r.Status != tt.status {
+				t.Errorf("status = %v, want %v", r.Status, tt.status)
+			}
+			if r.ExitCode != tt.exitCode {
+				t.Errorf("exit code = %d, want %d", r.ExitCode, tt.exitCode)
+			}
+			if !tt.check(r.Err) {
+				t.Errorf("unexpected error %v", r.Err)
+			}
+			if got := stdout.String(); got != tt.stdout {
+				t.Errorf("stdout = %q, want %q", got, tt.stdout)
+			}
+			if got := stderr.String(); got != tt.stderr {
+				t.Errorf("stderr = %q, want %q", got, tt.stderr)
+			}
+		})
+	}
+}
+
+// floodRunner starts processes that write size bytes to stdout and stderr
+// at the same time. Like a real process blocked on a full pipe, they only
+// exit once everything has been read.
+type floodRunner struct {
+	size int
+}
+
+func (floodRunner) LookPath(file string) (string, error) { return "/fake/" + file, nil }
+
+func (r floodRunner) Start(ctx context.Context, c *Command, so StartOptions) (Process, error) {
+	var wg sync.WaitGroup
+	flood := func() io.Reader {
+		rd, w := io.Pipe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			line := []byte(strings.Repeat("x", 1023) + "\n")
+			for n := 0; n < r.size; n += len(line) {
+				if _, err := w.Write(line); err != nil {
+					return
+				}
+			}
+			w.Close()
+		}()
+		return rd
+	}
+	return &fakeProcess{stdout: flood(), stderr: flood(), wait: wg.Wait}, nil
+}
+
+func TestRunLargeOutput(t *testing.T) {
+	const size = 8 << 20
+	for name, v := range map[string]Verbosity{"quiet": VerbosityQuiet, "normal": VerbosityNormal} {
+		t.Run(name, func(t *testing.T) {
+			opts := Options{
+				Runner:     floodRunner{size: size},
+				Stdout:     ioutil.Discard,
+				Stderr:     ioutil.Discard,
+				Verbosity:  v,
+				MaxCapture: 1 << 20,
+			}
+			checkNoHang(t, opts, Command{Name: "flood"})
+		})
+	}
+}
+
+func TestRunLargeOutputExec(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a shell")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh in PATH")
+	}
+	script := `head -c 8388608 /dev/zero | tr '\0' x & head -c 8388608 /dev/zero | tr '\0' y >&2; wait`
+	for name, v := range map[string]Verbosity{"quiet": VerbosityQuiet, "normal": VerbosityNormal} {
+		t.Run(name, func(t *testing.T) {
+			opts := Options{Stdout: ioutil.Discard, Stderr: ioutil.Discard, Verbosity: v, MaxCapture: 1 << 20}
+			checkNoHang(t, opts, Command{Name: "sh", Shell: script})
+		})
+	}
+}
+
+// checkNoHang runs c and fails unless it succeeds within a few seconds.
+func checkNoHang(t *testing.T, opts Options, c Command) {
+	t.Helper()
+	done := make(chan []Result, 1)
+	go func() {
+		results, _ := Run(context.Background(), []Command{c}, opts)
+		done <- results
+	}()
+	select {
+	case results := <-done:
+		if s := results[0].Status; s != StatusOK {
+			t.Errorf("status = %v, want %v: %v", s, StatusOK, results[0].Err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("Run did not return")
+	}
+}
@@ -0,0 +1,182 @@
+package updater
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// Runner starts the processes behind commands. It exists so that execution
+// can be replaced, e.g. with a fake that returns canned output.
+type Runner interface {
+	LookPath(file string) (string, error)
+	Start(ctx context.Context, c *Command, so StartOptions) (Process, error)
+}
+
+// StartOptions holds the settings of a run that are not part of the
+// Command itself.
+type StartOptions struct {
+	// Stdin is connected to the standard input of the process; it gets
+	// no input when Stdin is nil.
+	Stdin io.Reader
+	// Grace is how long the process is given to exit after being asked to
+	// terminate when the context is done, before it is killed.
+	Grace time.Duration
+	// FreshEnv starts the process with only PATH, HOME and the Env of the
+	// command instead of the whole current environment.
+	FreshEnv bool
+}
+
+// Process is a started command. Both outputs must be read to EOF before
+// calling Wait.
+type Process interface {
+	Stdout() io.Reader
+	Stderr() io.Reader
+	Wait() error
+	Pid() int
+	// ExitCode returns the exit code of the exited process, or -1 if it
+	// has not exited or was terminated by a signal.
+	ExitCode() int
+	// Killed reports whether the process had to be killed because it did
+	// not exit within the grace period.
+	Killed() bool
+}
+
+// ExecRunner runs commands as processes with os/exec.
+type ExecRunner struct{}
+
+func (ExecRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func (ExecRunner) Start(ctx context.Context, c *Command, so StartOptions) (Process, error) {
+	name, args := c.Argv()
+	cmd := exec.Command(name, args...)
+	if c.Host == "" {
+		cmd.Dir = c.Dir
+	}
+	cmd.Env = c.environ(so.FreshEnv)
+	cmd.Stdin = so.Stdin
+	tty := terminal(so.Stdin)
+	if !setProcessGroup(cmd, tty) {
+		tty = nil
+	}
+
+	// The pipes are made here rather than by os/exec, so that Wait can
+	// return as soon as the process exits instead of when the pipes are
+	// closed, which its children may delay.
+	stdout, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutW.Close()
+		return nil, err
+	}
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+
+	err = cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		return nil, err
+	}
+	p := &execProcess{cmd: cmd, stdout: stdout, stderr: stderr, exited: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		if tty != nil {
+			leaveForeground(tty, cmd.ProcessState)
+		}
+		close(p.exited)
+	}()
+	go p.stop(ctx, so.Grace)
+	return p, nil
+}
+
+// pipeLinger is how long the output of a stopped command is still read
+// after it has exited.
+const pipeLinger = 100 * time.Millisecond
+
+// terminal returns r when it is a terminal.
+func terminal(r io.Reader) *os.File {
+	f, ok := r.(*os.File)
+	if !ok {
+		return nil
+	}
+	if !IsTerminal(f) {
+		return nil
+	}
+	return f
+}
+
+type execProcess struct {
+	cmd    *exec.Cmd
+	stdout *os.File
+	stderr *os.File
+	// exited is closed once the process itself has exited, with err as
+	// the result of waiting for it.
+	exited chan struct{}
+	err    error
+	killed int32
+}
+
+// stop terminates the process once ctx is done, and kills it if it is
+// still running after grace.
+func (p *execProcess) stop(ctx context.Context, grace time.Duration) {
+	select {
+	case <-p.exited:
+		return
+	case <-ctx.Done():
+	}
+
+	if grace > 0 && terminate(p.cmd) == nil {
+		select {
+		case <-p.exited:
+		case <-time.After(grace):
+			atomic.StoreInt32(&p.killed, 1)
+			kill(p.cmd)
+		}
+	} else {
+		kill(p.cmd)
+	}
+
+	// Processes that escaped the group could keep the pipes open long
+	// after the command is gone. Once it has exited, they are only given a
+	// moment to finish writing before the pipes are closed on them.
+	<-p.exited
+	time.Sleep(pipeLinger)
+	p.stdout.Close()
+	p.stderr.Close()
+}
+
+func (p *execProcess) Stdout() io.Reader { return p.stdout }
+func (p *execProcess) Stderr() io.Reader { return p.stderr }
+func (p *execProcess) Pid() int          { return p.cmd.Process.Pid }
+func (p *execProcess) Killed() bool      { return atomic.LoadInt32(&p.killed) == 1 }
+
+func (p *execProcess) Wait() error {
+	<-p.exited
+	p.stdout.Close()
+	p.stderr.Close()
+	return p.err
+}
+
+func (p *execProcess) ExitCode() int {
+	select {
+	case <-p.exited:
+	default:
+		return -1
+	}
+	if p.cmd.ProcessState == nil {
+		return -1
+	}
+	return p.cmd.ProcessState.ExitCode()
+}
@@ -0,0 +1,34 @@
+package updater
+
+import (
+	"runtime"
+	"strings"
+)
+
+// shellCommand returns the program and arguments that run line through the
+// system shell.
+func shellCommand(line string) (string, []string) {
+	if runtime.GOOS == "windows" {
+		return "cmd", []string{"/c", line}
+	}
+	return "sh", []string{"-c", line}
+}
+
+// ShellQuote quotes s for a POSIX shell when it contains anything other than
+// characters that are safe to leave bare.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
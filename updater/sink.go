@@ -0,0 +1,68 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+// lineSink receives the lines of output of a command as they are read.
+type lineSink interface {
+	line(s string)
+}
+
+// textSink logs each line under prefix, after the timestamp selected by
+// opts. Lines longer than opts.MaxLogLine are cut short.
+type textSink struct {
+	logger *log.Logger
+	prefix string
+	opts   *Options
+	start  time.Time
+}
+
+func newTextSink(w io.Writer, prefix string, opts *Options, start time.Time) *textSink {
+	return &textSink{logger: log.New(w, "", 0), prefix: prefix, opts: opts, start: start}
+}
+
+func (s *textSink) line(row string) {
+	head := s.opts.timestamp(s.start) + s.prefix
+	if s.opts.MaxLogLine > 0 {
+		room := s.opts.MaxLogLine - utf8.RuneCountInString(StripColor(head))
+		row = truncateLine(row, room)
+	}
+	s.logger.Print(head + row)
+}
+
+// jsonLine is the object written by jsonlSink for every line.
+type jsonLine struct {
+	Command string    `json:"command"`
+	Stream  string    `json:"stream"`
+	Line    string    `json:"line"`
+	TS      time.Time `json:"ts"`
+}
+
+// jsonlSink writes each line as a JSON object of its own.
+type jsonlSink struct {
+	w      io.Writer
+	name   string
+	stream string
+}
+
+func newJSONLSink(w io.Writer, name, stream string) *jsonlSink {
+	return &jsonlSink{w: w, name: name, stream: stream}
+}
+
+func (s *jsonlSink) line(row string) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(jsonLine{Command: s.name, Stream: s.stream, Line: row, TS: time.Now()}); err != nil {
+		return
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	s.w.Write(b.Bytes())
+}
@@ -0,0 +1,8 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package updater
+
+import "syscall"
+
+const ioctlGetTermios = syscall.TIOCGETA
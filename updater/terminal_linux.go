@@ -0,0 +1,5 @@
+package updater
+
+import "syscall"
+
+const ioctlGetTermios = syscall.TCGETS
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package updater
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// IsTerminal reports whether f is a terminal. Unlike a check for a
+// character device, it is false for /dev/null.
+func IsTerminal(f *os.File) bool {
+	var t syscall.Termios
+	return ioctl(f, ioctlGetTermios, unsafe.Pointer(&t)) == nil
+}
+
+// TerminalWidth returns the number of columns of the terminal f, or 0 if f
+// is not a terminal or does not know its size.
+func TerminalWidth(f *os.File) int {
+	var ws struct{ Row, Col, Xpixel, Ypixel uint16 }
+	if err := ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0
+	}
+	return int(ws.Col)
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
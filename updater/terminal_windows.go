@@ -0,0 +1,17 @@
+package updater
+
+import (
+	"os"
+	"syscall"
+)
+
+// IsTerminal reports whether f is a console.
+func IsTerminal(f *os.File) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode) == nil
+}
+
+// TerminalWidth always returns 0; the size of a console is not looked up.
+func TerminalWidth(f *os.File) int {
+	return 0
+}
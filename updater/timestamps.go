@@ -0,0 +1,30 @@
+package updater
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimestampMode selects what is put in front of each logged line of
+// output.
+type TimestampMode int
+
+const (
+	TimestampsOff TimestampMode = iota
+	// TimestampsWall shows the time of day.
+	TimestampsWall
+	// TimestampsRelative shows the time since the command started.
+	TimestampsRelative
+)
+
+// timestamp returns the stamp for a line logged now by a command that
+// started at start, including its trailing space.
+func (o *Options) timestamp(start time.Time) string {
+	switch o.Timestamps {
+	case TimestampsWall:
+		return time.Now().Format("15:04:05") + " "
+	case TimestampsRelative:
+		return fmt.Sprintf("%+8.1fs ", time.Since(start).Seconds())
+	}
+	return ""
+}
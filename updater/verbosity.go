@@ -0,0 +1,15 @@
+package updater
+
+// Verbosity controls how much is logged while commands run. The zero value
+// streams every line of the commands' output.
+type Verbosity int
+
+const (
+	// VerbosityQuiet logs nothing but the errors of failed commands.
+	VerbosityQuiet Verbosity = iota - 2
+	// VerbosityBrief only logs when a command starts and finishes.
+	VerbosityBrief
+	VerbosityNormal
+	// VerbosityDebug additionally logs what the tool itself is doing.
+	VerbosityDebug
+)
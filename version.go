@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set through -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version string
+	commit  string
+)
+
+func versionString() string {
+	v, c := version, commit
+	if v == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			v = info.Main.Version
+		}
+	}
+	if v == "" {
+		v = "unknown"
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	return fmt.Sprintf("update %s (commit %s, %s)", v, c, runtime.Version())
+}
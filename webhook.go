@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// headerFlag collects the repeated values of -webhook-header.
+type headerFlag []string
+
+func (f *headerFlag) String() string { return strings.Join(*f, ", ") }
+
+func (f *headerFlag) Set(s string) error {
+	if !strings.Contains(s, ":") {
+		return fmt.Errorf("expected Name: value")
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// postWebhook posts the report body to url with the given "Name: value"
+// headers.
+func postWebhook(url string, headers []string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, h := range headers {
+		i := strings.IndexByte(h, ':')
+		req.Header.Set(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}